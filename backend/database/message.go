@@ -6,23 +6,57 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // メッセージ送信
-func SendMessage(db *gorm.DB, roomID uint, senderID uint, content string, threadRootID *uint) (models.Message, error) {
+// clientMsgID が同じ送信者で既に使われていれば、新しく作らずに既存のメッセージを返す（duplicate = true）
+func SendMessage(db *gorm.DB, roomID uint, senderID uint, content string, threadRootID *uint, clientMsgID *string) (models.Message, bool, error) {
 	message := models.Message{
 		RoomID:       roomID,
 		SenderID:     senderID,
 		Content:      content,
 		ThreadRootID: threadRootID,
+		ClientMsgID:  clientMsgID,
 		CreatedAt:    time.Now(),
 	}
 
-	if err := db.Create(&message).Error; err != nil {
-		return models.Message{}, err
+	duplicate, err := CreateMessage(db, &message)
+	if err != nil {
+		return models.Message{}, false, err
 	}
 
-	return message, nil
+	return message, duplicate, nil
+}
+
+// メッセージを保存する（client_msg_id の重複時は既存のメッセージで message を上書きして true を返す）
+func CreateMessage(db *gorm.DB, message *models.Message) (bool, error) {
+	if message.ClientMsgID == nil || *message.ClientMsgID == "" {
+		message.ClientMsgID = nil
+		return false, db.Create(message).Error
+	}
+
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sender_id"}, {Name: "client_msg_id"}},
+		DoNothing: true,
+	}).Create(message)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return false, nil
+	}
+
+	// 既に保存済み → 既存のメッセージを返す
+	var existing models.Message
+	if err := db.Unscoped().
+		Preload("Attachments").
+		Where("sender_id = ? AND client_msg_id = ?", message.SenderID, *message.ClientMsgID).
+		First(&existing).Error; err != nil {
+		return false, err
+	}
+	*message = existing
+	return true, nil
 }
 
 // ルーム内のメッセージ取得（user1ID, user2ID間ではなくroom_idで取得するように変更推奨）
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"gorm.io/gorm"
+)
+
+// メッセージ検索用のカラムとインデックス（AutoMigrate の後に実行する）
+//   - content_tsv: 英数字の単語検索用（to_tsvector の生成カラム + GIN）
+//   - pg_trgm: 日本語など空白で区切らない文字列の部分一致（ILIKE）用のトライグラム GIN
+//
+// 何度実行しても同じ結果になるようにしている
+var searchMigrations = []string{
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_tsv tsvector
+		GENERATED ALWAYS AS (to_tsvector('simple', coalesce(content, ''))) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_messages_content_tsv ON messages USING GIN (content_tsv)`,
+	`CREATE INDEX IF NOT EXISTS idx_messages_content_trgm ON messages USING GIN (content gin_trgm_ops)`,
+}
+
+// 検索用のカラム・インデックスを作成する
+func MigrateSearch(db *gorm.DB) error {
+	for _, stmt := range searchMigrations {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
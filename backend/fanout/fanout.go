@@ -0,0 +1,36 @@
+// リアルタイム配信イベントをサーバー間で共有する仕組み
+//
+// 各サーバーは自分が Publish したイベントも含めて Subscribe で受け取り、
+// 自分に接続している WebSocket クライアントへ配信する。
+package fanout
+
+import "encoding/json"
+
+// イベントの種類
+const (
+	KindRoom        = "room"        // ルームの購読者全員に送る
+	KindUsers       = "users"       // 指定ユーザーの全接続に送る
+	KindSubscribe   = "subscribe"   // ユーザーの接続をルームに参加させる
+	KindUnsubscribe = "unsubscribe" // ユーザーの接続をルームから外す
+	KindDisconnect  = "disconnect"  // ユーザー（またはそのセッション）の接続を切断する
+)
+
+// サーバー間で流れるイベント
+type Event struct {
+	Kind       string          `json:"kind"`
+	RoomID     uint            `json:"room_id,omitempty"`
+	UserID     uint            `json:"user_id,omitempty"`     // subscribe / unsubscribe / disconnect の対象
+	SessionID  string          `json:"session_id,omitempty"`  // disconnect でセッションを絞る場合
+	ExceptUser uint            `json:"except_user,omitempty"` // room で送らないユーザー
+	UserIDs    []uint          `json:"user_ids,omitempty"`    // users の宛先
+	Data       json.RawMessage `json:"data,omitempty"`        // クライアントに送る JSON
+}
+
+// 配信バックエンド
+type Backend interface {
+	// 全サーバー（自分を含む）にイベントを送る
+	Publish(ev Event) error
+	// 受け取ったイベントの処理を登録する（Publish より前に呼ぶ）
+	Subscribe(handler func(Event))
+	Close() error
+}
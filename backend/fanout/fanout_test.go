@@ -0,0 +1,147 @@
+package fanout
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMemoryDeliversToEverySubscriber(t *testing.T) {
+	tests := []struct {
+		name        string
+		subscribers int
+	}{
+		{"no subscribers", 0},
+		{"one subscriber", 1},
+		{"several subscribers", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			got := make([]Event, tt.subscribers)
+			for i := 0; i < tt.subscribers; i++ {
+				i := i
+				m.Subscribe(func(ev Event) { got[i] = ev })
+			}
+
+			ev := Event{Kind: KindRoom, RoomID: 5, Data: json.RawMessage(`{"type":"message"}`)}
+			if err := m.Publish(ev); err != nil {
+				t.Fatal(err)
+			}
+			for i, g := range got {
+				if !reflect.DeepEqual(g, ev) {
+					t.Errorf("subscriber %d got %+v, want %+v", i, g, ev)
+				}
+			}
+		})
+	}
+}
+
+// NOTIFY に直接載るイベント（Ref なし）は DB を使わずに配られる
+func TestPostgresHandleInlinePayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    []Event
+	}{
+		{"room", `{"kind":"room","room_id":3,"except_user":7,"data":{"type":"typing"}}`,
+			[]Event{{Kind: KindRoom, RoomID: 3, ExceptUser: 7, Data: json.RawMessage(`{"type":"typing"}`)}}},
+		{"users", `{"kind":"users","user_ids":[1,2]}`,
+			[]Event{{Kind: KindUsers, UserIDs: []uint{1, 2}}}},
+		{"disconnect", `{"kind":"disconnect","user_id":4,"session_id":"s1"}`,
+			[]Event{{Kind: KindDisconnect, UserID: 4, SessionID: "s1"}}},
+		{"invalid json", `{"kind":`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Postgres{}
+			var got []Event
+			p.Subscribe(func(ev Event) { got = append(got, ev) })
+
+			p.handle([]byte(tt.payload))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 2台のサーバーを想定して、片方から送ったイベントが両方に届くか確認する
+// TEST_DATABASE_URL が設定されているときだけ実行する
+func TestPostgresAcrossServers(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	received := map[string][]Event{}
+	servers := map[string]*Postgres{}
+	for _, name := range []string{"a", "b"} {
+		p, err := NewPostgres(dsn, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := name
+		p.Subscribe(func(ev Event) {
+			mu.Lock()
+			received[name] = append(received[name], ev)
+			mu.Unlock()
+		})
+		servers[name] = p
+		t.Cleanup(func() { p.Close() })
+	}
+	// LISTEN の接続が張られるのを待つ
+	time.Sleep(500 * time.Millisecond)
+
+	tests := []struct {
+		name string
+		ev   Event
+	}{
+		{"small event", Event{Kind: KindRoom, RoomID: 1, Data: json.RawMessage(`{"type":"message"}`)}},
+		{"event larger than NOTIFY allows", Event{Kind: KindRoom, RoomID: 2,
+			Data: json.RawMessage(`{"content":"` + strings.Repeat("x", pgMaxPayload) + `"}`)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			received = map[string][]Event{}
+			mu.Unlock()
+
+			if err := servers["a"].Publish(tt.ev); err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := time.Now().Add(3 * time.Second)
+			for {
+				mu.Lock()
+				done := len(received["a"]) == 1 && len(received["b"]) == 1
+				mu.Unlock()
+				if done || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, name := range []string{"a", "b"} {
+				if len(received[name]) != 1 || !reflect.DeepEqual(received[name][0], tt.ev) {
+					t.Errorf("server %s received %d events, want the published one", name, len(received[name]))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package fanout
+
+import "sync"
+
+// 1台構成・テスト用のバックエンド（同じプロセス内でだけ配信する）
+type Memory struct {
+	mu       sync.RWMutex
+	handlers []func(Event)
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Publish(ev Event) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, h := range m.handlers {
+		h(ev)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(handler func(Event)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, handler)
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,183 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// LISTEN / NOTIFY に使うチャンネル名
+	pgChannel = "chat_fanout"
+	// NOTIFY のペイロード上限（8000バイト）より少し小さく
+	pgMaxPayload = 7900
+	// 大きいペイロードを保持しておく時間
+	pgPayloadTTL = 5 * time.Minute
+)
+
+// NOTIFY に載せきれないイベントの一時置き場
+type pgPayload struct {
+	ID        uint   `gorm:"primaryKey"`
+	Data      []byte `gorm:"type:bytea;not null"`
+	CreatedAt time.Time
+}
+
+func (pgPayload) TableName() string {
+	return "fanout_payloads"
+}
+
+// NOTIFY で流す本体（大きいイベントは Ref だけを流して受信側でテーブルから読む）
+type pgEnvelope struct {
+	Event
+	Ref uint `json:"ref,omitempty"`
+}
+
+// 複数サーバー構成用のバックエンド（既存の Postgres の LISTEN / NOTIFY を使う）
+type Postgres struct {
+	dsn      string
+	db       *gorm.DB
+	mu       sync.RWMutex
+	handlers []func(Event)
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// dsn は LISTEN 専用の接続に、db は NOTIFY の送信に使う
+func NewPostgres(dsn string, db *gorm.DB) (*Postgres, error) {
+	if err := db.AutoMigrate(&pgPayload{}); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Postgres{
+		dsn:    dsn,
+		db:     db,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go p.listen(ctx)
+	go p.cleanup(ctx)
+	return p, nil
+}
+
+func (p *Postgres) Publish(ev Event) error {
+	data, err := json.Marshal(pgEnvelope{Event: ev})
+	if err != nil {
+		return err
+	}
+
+	if len(data) > pgMaxPayload {
+		row := pgPayload{Data: data}
+		if err := p.db.Create(&row).Error; err != nil {
+			return err
+		}
+		data, _ = json.Marshal(pgEnvelope{Ref: row.ID})
+	}
+
+	return p.db.Exec("SELECT pg_notify(?, ?)", pgChannel, string(data)).Error
+}
+
+func (p *Postgres) Subscribe(handler func(Event)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers = append(p.handlers, handler)
+}
+
+func (p *Postgres) Close() error {
+	p.cancel()
+	<-p.done
+	return nil
+}
+
+// 切断されても再接続して LISTEN を続ける
+func (p *Postgres) listen(ctx context.Context) {
+	defer close(p.done)
+
+	backoff := time.Second
+	for ctx.Err() == nil {
+		connected, err := p.listenOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		log.Printf("⚠️ fanout LISTEN error: %v (retry in %s)\n", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (p *Postgres) listenOnce(ctx context.Context) (bool, error) {
+	conn, err := pgx.Connect(ctx, p.dsn)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgChannel); err != nil {
+		return false, err
+	}
+	log.Println("✅ fanout: listening on", pgChannel)
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		p.handle([]byte(n.Payload))
+	}
+}
+
+func (p *Postgres) handle(payload []byte) {
+	var env pgEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		log.Println("❌ fanout: invalid payload:", err)
+		return
+	}
+
+	if env.Ref != 0 {
+		var row pgPayload
+		if err := p.db.First(&row, env.Ref).Error; err != nil {
+			log.Println("❌ fanout: payload fetch error:", err)
+			return
+		}
+		env = pgEnvelope{}
+		if err := json.Unmarshal(row.Data, &env); err != nil {
+			log.Println("❌ fanout: invalid payload:", err)
+			return
+		}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, h := range p.handlers {
+		h(env.Event)
+	}
+}
+
+// 配り終わった大きいペイロードを定期的に削除
+func (p *Postgres) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.db.Where("created_at < ?", time.Now().Add(-pgPayloadTTL)).Delete(&pgPayload{})
+		}
+	}
+}
@@ -3,11 +3,12 @@ package handlers
 import (
 	"backend/models"
 	"errors"
+	"log"
 	"net/http"
-	"time"
+	"net/mail"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -26,22 +27,41 @@ func SetDB(database *gorm.DB) {
 // - リクエスト: POST /signup
 // - 呼び出し元: frontend の SignupPage（例: pages/signup.tsx）から fetch
 // - 処理:
-//  1. JSONリクエストボディをパース（username, password）
-//  2. ユーザー名の重複チェック
+//  1. JSONリクエストボディをパース（username, password, email）
+//  2. ユーザー名・パスワードのルールと、ユーザー名・メールアドレスの重複をチェック
 //  3. パスワードをハッシュ化（bcrypt）
 //  4. 新しいユーザーをDBに保存
+//  5. メールアドレス確認のリンクを送信
 func SignUpHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// リクエストのパース
 		var input struct {
 			Username string `json:"username"`
 			Password string `json:"password"`
+			Email    string `json:"email"`
 		}
 		if err := c.ShouldBindJSON(&input); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 			return
 		}
 
+		// ユーザー名・パスワードのルールチェック
+		if err := validateUsername(input.Username); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validatePassword(input.Password, input.Username); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// メールアドレスの形式チェック（表示名付きなどは受け付けない）
+		email := normalizeEmail(input.Email)
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "メールアドレスの形式が正しくありません"})
+			return
+		}
+
 		// 重複チェック：既に同名のユーザーが存在するか？
 		var existing models.User
 		if err := db.Where("username = ?", input.Username).First(&existing).Error; err == nil {
@@ -51,6 +71,13 @@ func SignUpHandler(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "データベースエラー"})
 			return
 		}
+		if err := db.Where("email = ?", email).First(&existing).Error; err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "このメールアドレスは既に使われています"})
+			return
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "データベースエラー"})
+			return
+		}
 
 		// パスワードをハッシュ化
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
@@ -63,16 +90,28 @@ func SignUpHandler(db *gorm.DB) gin.HandlerFunc {
 		user := models.User{
 			Username:     input.Username,
 			PasswordHash: string(hashedPassword),
+			Email:        email,
 		}
 		if err := db.Create(&user).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー作成に失敗しました"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "ユーザー登録に成功しました"})
+		// 確認メールはバックグラウンドで送る（届かなくても登録自体は成功。POST /email/resend で送り直せる）
+		if err := sendVerificationEmail(user); err != nil {
+			log.Println("❌ verification mail error:", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "ユーザー登録に成功しました。確認メールを送信しました"})
 	}
 }
 
+// ログイン失敗時のエラー（ユーザーの有無・パスワードの誤りを区別しない）
+const loginFailedMessage = "ユーザー名またはパスワードが正しくありません"
+
+// 存在しないユーザーでも照合にかかる時間をそろえるためのハッシュ
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
 // ==============================
 // 🔹 ログインハンドラー
 // ==============================
@@ -80,46 +119,85 @@ func SignUpHandler(db *gorm.DB) gin.HandlerFunc {
 // - 呼び出し元: frontend の LoginPage（例: pages/login.tsx）から fetch
 // - 処理:
 //  1. JSONで送られた username/password をパース
-//  2. ユーザーが存在するか確認
-//  3. パスワード照合（bcrypt）
-//  4. JWTトークンを生成して返却
+//  2. 失敗が続いているアカウント・IP なら待ち時間が過ぎるまで拒否（429）
+//  3. ユーザーの確認とパスワード照合（bcrypt）。失敗時はどちらでも同じエラー
+//  4. 二要素認証が有効ならチャレンジトークンを返して終了（2段階目は POST /login/2fa）
+//  5. セッションを記録し、アクセストークン（JWT）とリフレッシュトークンを生成して返却
 func LoginHandler(c *gin.Context) {
 	var input struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Device   string `json:"device"` // 任意（セッション一覧に表示する端末名）
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
 
+	// 失敗が続いているアカウント・IP はパスワードを確認せずに拒否
+	ip := c.ClientIP()
+	if wait := loginGuards.blocked(accountKey(input.Username), ipKey(ip)); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "ログインの試行回数が多すぎます。しばらくしてから再度お試しください"})
+		return
+	}
+
+	// ユーザーの有無が分からないよう、存在しない場合もハッシュの比較を行い、同じエラーを返す
 	var user models.User
-	if err := db.Where("username = ?", input.Username).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ユーザーが見つかりません"})
+	found := db.Where("username = ?", input.Username).First(&user).Error == nil
+	hash := dummyPasswordHash
+	if found {
+		hash = []byte(user.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(input.Password)); err != nil || !found {
+		var userID *uint
+		if found {
+			userID = &user.ID
+		}
+		loginGuards.recordFailure(input.Username, ip, userID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": loginFailedMessage})
 		return
 	}
 
-	// パスワードの照合（ハッシュと比較）
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "パスワードが正しくありません"})
+	// 二要素認証が有効なら、トークンの代わりにチャレンジトークンを返す（POST /login/2fa へ）
+	if user.TOTPEnabled {
+		challenge, err := issueChallengeToken(user.ID, input.Device)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "トークン生成に失敗しました"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":             "二要素認証コードを入力してください",
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_in":          int64(challengeTokenTTL.Seconds()),
+		})
 		return
 	}
 
-	// JWTトークン生成（有効期限: 24時間）
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
-	})
-	tokenString, err := token.SignedString(jwtSecret)
+	// 失敗回数のリセットはログインが完了したときだけ（2FA ありなら POST /login/2fa の成功時）
+	loginGuards.recordSuccess(input.Username)
+
+	// セッションを作成し、アクセストークン（短命）とリフレッシュトークンを発行
+	pair, err := startSession(c, user.ID, input.Device)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークン生成に失敗しました"})
 		return
 	}
+	respondWithTokens(c, pair)
+}
 
+// ログイン成功のレスポンス（/login と /login/2fa で共通）
+func respondWithTokens(c *gin.Context, pair tokenPair) {
 	// レスポンスヘッダーにトークンを含める（任意）
-	c.Header("Authorization", "Bearer "+tokenString)
+	c.Header("Authorization", "Bearer "+pair.Token)
 
-	c.JSON(http.StatusOK, gin.H{"message": "ログイン成功", "token": tokenString})
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "ログイン成功",
+		"token":         pair.Token,
+		"refresh_token": pair.RefreshToken,
+		"expires_in":    pair.ExpiresIn,
+	})
 }
 
 // ==============================
@@ -154,8 +232,67 @@ func MeHandler(db *gorm.DB) gin.HandlerFunc {
 
 		// パスワードハッシュなどの機密情報は返さない
 		c.JSON(http.StatusOK, gin.H{
-			"id":       user.ID,
-			"username": user.Username,
+			"id":             user.ID,
+			"username":       user.Username,
+			"email":          user.Email,
+			"email_verified": user.EmailVerifiedAt != nil,
+			"totp_enabled":   user.TOTPEnabled,
 		})
 	}
 }
+
+// ==============================
+// 🔹 ログアウトハンドラー
+// ==============================
+// - リクエスト: POST /logout
+// - 処理:
+//  1. 使用中のアクセストークンとそのセッションを失効させる
+//  2. セッションのリフレッシュトークンも使えなくする
+//  3. セッションで接続中の WebSocket / SSE を切断する
+func LogoutHandler(c *gin.Context) {
+	claims := GetAccessClaims(c)
+	if claims == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "ユーザーIDが取得できません"})
+		return
+	}
+
+	var err error
+	if claims.SessionID != "" {
+		err = revocations.revokeSession(claims.UserID, claims.SessionID)
+	} else {
+		err = revocations.revokeToken(claims)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ログアウトに失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "ログアウトしました"})
+}
+
+// ==============================
+// 🔹 全端末ログアウトハンドラー
+// ==============================
+// - リクエスト: POST /logout/all
+// - 処理: ユーザーの全セッションを失効させ、全接続を切断する
+func LogoutAllHandler(c *gin.Context) {
+	claims := GetAccessClaims(c)
+	if claims == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "ユーザーIDが取得できません"})
+		return
+	}
+
+	if err := revocations.revokeAllSessions(claims.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ログアウトに失敗しました"})
+		return
+	}
+	// セッションを持たない古いトークンで呼ばれた場合も、そのトークンは失効させる
+	if claims.SessionID == "" {
+		if err := revocations.revokeToken(claims); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "ログアウトに失敗しました"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "すべての端末からログアウトしました"})
+}
@@ -0,0 +1,46 @@
+// handlers/config.go
+package handlers
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 環境変数から時間を読む（未設定・不正なら def）
+// 例: WS_PING_INTERVAL=30s
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("⚠️ invalid %s=%q, using default %s\n", name, v, def)
+		return def
+	}
+	return d
+}
+
+// 環境変数から文字列を読む（未設定なら def）
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// 環境変数から整数を読む（未設定・不正なら def）
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("⚠️ invalid %s=%q, using default %d\n", name, v, def)
+		return def
+	}
+	return n
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnvDuration(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"unset", "", 10 * time.Second},
+		{"valid", "30s", 30 * time.Second},
+		{"minutes", "2m", 2 * time.Minute},
+		{"not a duration", "abc", 10 * time.Second},
+		{"zero", "0s", 10 * time.Second},
+		{"negative", "-5s", 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_ENV_DURATION", tt.value)
+			if got := envDuration("TEST_ENV_DURATION", 10*time.Second); got != tt.want {
+				t.Errorf("envDuration(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvInt(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  int
+	}{
+		{"unset", "", 8},
+		{"valid", "12", 12},
+		{"zero", "0", 0},
+		{"not a number", "abc", 8},
+		{"negative", "-1", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_ENV_INT", tt.value)
+			if got := envInt("TEST_ENV_INT", 8); got != tt.want {
+				t.Errorf("envInt(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
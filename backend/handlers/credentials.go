@@ -0,0 +1,114 @@
+// handlers/credentials.go
+package handlers
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ==============================
+// 🔹 ユーザー名・パスワードのルール（環境変数で変更可能）
+// ==============================
+// - USERNAME_MIN_LENGTH / USERNAME_MAX_LENGTH : ユーザー名の長さ（既定 3〜32文字）
+// - USERNAME_PATTERN                          : ユーザー名に使える文字（正規表現、既定は英数字と _ . -）
+// - PASSWORD_MIN_LENGTH                       : パスワードの最小文字数（既定 8）
+// - PASSWORD_MIN_CLASSES                      : 含めるべき文字種の数（英小文字・英大文字・数字・記号のうち、既定 2）
+var (
+	usernameMinLength  = envInt("USERNAME_MIN_LENGTH", 3)
+	usernameMaxLength  = envInt("USERNAME_MAX_LENGTH", 32)
+	usernamePattern    = mustCompileEnvRegexp("USERNAME_PATTERN", `^[A-Za-z0-9_.-]+$`)
+	passwordMinLength  = envInt("PASSWORD_MIN_LENGTH", 8)
+	passwordMinClasses = envInt("PASSWORD_MIN_CLASSES", 2)
+)
+
+// bcrypt は 72 バイトより後ろを無視するので、それより長いパスワードは受け付けない
+const passwordMaxBytes = 72
+
+// よく使われる・流出済みのパスワード一覧
+//
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = parseCommonPasswords(commonPasswordList)
+
+func parseCommonPasswords(list string) map[string]bool {
+	result := make(map[string]bool)
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		result[strings.ToLower(line)] = true
+	}
+	return result
+}
+
+func mustCompileEnvRegexp(name, def string) *regexp.Regexp {
+	pattern := envString(name, def)
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Printf("⚠️ invalid %s=%q, using default %s\n", name, pattern, def)
+		return regexp.MustCompile(def)
+	}
+	return re
+}
+
+// ユーザー名がルールを満たしているか確認する（エラーはそのまま画面に出せる文言）
+func validateUsername(username string) error {
+	n := utf8.RuneCountInString(username)
+	if n < usernameMinLength || n > usernameMaxLength {
+		return fmt.Errorf("ユーザー名は%d〜%d文字で入力してください", usernameMinLength, usernameMaxLength)
+	}
+	if !usernamePattern.MatchString(username) {
+		return errors.New("ユーザー名に使えない文字が含まれています")
+	}
+	return nil
+}
+
+// パスワードがルールを満たしているか確認する（エラーはそのまま画面に出せる文言）
+func validatePassword(password, username string) error {
+	if utf8.RuneCountInString(password) < passwordMinLength {
+		return fmt.Errorf("パスワードは%d文字以上で入力してください", passwordMinLength)
+	}
+	if len(password) > passwordMaxBytes {
+		return fmt.Errorf("パスワードは%dバイト以内で入力してください", passwordMaxBytes)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < passwordMinClasses {
+		return fmt.Errorf("パスワードには英小文字・英大文字・数字・記号のうち%d種類以上を含めてください", passwordMinClasses)
+	}
+
+	lowered := strings.ToLower(password)
+	if commonPasswords[lowered] {
+		return errors.New("このパスワードはよく使われているため利用できません")
+	}
+	if username != "" && strings.Contains(lowered, strings.ToLower(username)) {
+		return errors.New("パスワードにユーザー名を含めることはできません")
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"backend/models"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		wantErr  bool
+	}{
+		{"alice", false},
+		{"a_b.c-1", false},
+		{"abc", false},
+		{strings.Repeat("a", 32), false},
+		{"ab", true},
+		{strings.Repeat("a", 33), true},
+		{"alice smith", true},
+		{"alice@example", true},
+		{"ありす太郎", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		if err := validateUsername(tt.username); (err != nil) != tt.wantErr {
+			t.Errorf("validateUsername(%q) = %v, wantErr %v", tt.username, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		username string
+		wantErr  bool
+	}{
+		{"two classes", "horse-battery", "alice", false},
+		{"multibyte counts as characters", "パスワードです12", "alice", false},
+		{"too short", "abc-123", "alice", true},
+		{"one class", "horsebattery", "alice", true},
+		{"longer than bcrypt accepts", strings.Repeat("a1", 37), "alice", true},
+		{"common password", "password1", "alice", true},
+		{"common password in other case", "PassWord1", "alice", true},
+		{"contains the username", "my-Alice-2024", "alice", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePassword(tt.password, tt.username); (err != nil) != tt.wantErr {
+				t.Errorf("validatePassword(%q) = %v, wantErr %v", tt.password, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseCommonPasswords(t *testing.T) {
+	got := parseCommonPasswords("# comment\nPassword\n\n  qwerty  \n")
+	if len(got) != 2 || !got["password"] || !got["qwerty"] {
+		t.Errorf("got %v, want password and qwerty", got)
+	}
+}
+
+func TestSignUpHandler(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	m := useTestMailer(t)
+
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		email      string
+		wantStatus int
+	}{
+		{"invalid username", "a b", "horse-battery", "ab@example.com", http.StatusBadRequest},
+		{"weak password", "alice", "password", "alice@example.com", http.StatusBadRequest},
+		{"invalid email", "alice", "horse-battery", "Alice <alice@example.com>", http.StatusBadRequest},
+		{"valid", "alice", "horse-battery", " Alice@Example.com ", http.StatusOK},
+		{"username taken", "alice", "horse-battery", "other@example.com", http.StatusConflict},
+		{"email taken", "alice2", "horse-battery", "alice@example.com", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]string{"username": tt.username, "password": tt.password, "email": tt.email}
+			w := serveAs(0, "POST", "/signup", "/signup", body, SignUpHandler(testDB))
+			expectStatus(t, w, tt.wantStatus)
+		})
+	}
+
+	var user models.User
+	if err := testDB.Where("username = ?", "alice").First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alice@example.com" || user.PasswordHash == "horse-battery" {
+		t.Errorf("stored user = %q / %q", user.Email, user.PasswordHash)
+	}
+	if mails := waitForMail(t, m, 1); len(mails) != 1 || mails[0].To != "alice@example.com" {
+		t.Errorf("mails = %+v, want a verification mail", mails)
+	}
+}
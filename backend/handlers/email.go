@@ -0,0 +1,88 @@
+// handlers/email.go
+package handlers
+
+import (
+	"backend/models"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==============================
+// 🔹 メールアドレス確認ハンドラー
+// ==============================
+// - リクエスト: POST /email/verify { "token": "..." }
+// - 呼び出し元: 確認メールのリンク先（pages/verify-email.tsx）
+// - 処理:
+//  1. トークンの署名・有効期限・用途を検証
+//  2. トークン発行後にメールアドレスが変わっていないことを確認
+//  3. email_verified_at を記録し、トークンを使用済みにする
+func VerifyEmailHandler(c *gin.Context) {
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	claims, extra, err := parsePurposeToken(emailVerifyTokenType, input.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "確認リンクが無効か、有効期限が切れています"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, claims.UserID).Error; err != nil || extra["email"] != user.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "確認リンクが無効か、有効期限が切れています"})
+		return
+	}
+
+	if user.EmailVerifiedAt == nil {
+		if err := db.Model(&user).Update("email_verified_at", time.Now()).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "メールアドレスの確認に失敗しました"})
+			return
+		}
+	}
+	if err := revocations.revokeToken(claims); err != nil {
+		log.Println("❌ verify token revoke error:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "メールアドレスを確認しました"})
+}
+
+// ==============================
+// 🔹 確認メール再送ハンドラー
+// ==============================
+// - リクエスト: POST /email/resend
+// - 処理: 未確認のメールアドレスに確認メールを送り直す（登録時と違い、送信の結果を返すため送信を待つ）
+func ResendVerificationHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	if user.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "メールアドレスが登録されていません"})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "メールアドレスは確認済みです"})
+		return
+	}
+
+	msg, err := verificationEmail(user)
+	if err == nil {
+		err = mailSender.Send(msg)
+	}
+	if err != nil {
+		log.Printf("❌ mail send error (to %s): %v\n", user.Email, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "確認メールの送信に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "確認メールを送信しました"})
+}
@@ -0,0 +1,168 @@
+// handlers/events.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==============================
+// 🔹 Server-Sent Events（WebSocket が使えないネットワーク向け）
+// ==============================
+// - リクエスト: GET /events?token=xxx（EventSource はヘッダーを付けられないのでクエリでも可）
+// - 配信内容: /ws と同じイベント（message / update / delete / read など）
+// - 形式: event: にイベント種別、data: に /ws と同じ JSON、message イベントは id: にメッセージID
+// - 再接続: Last-Event-ID ヘッダー（または last_message_id / cursors クエリ）以降を再送してから配信
+func EventsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("Authorization")
+		if token == "" {
+			token = c.Query("token")
+		}
+		if token == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+			return
+		}
+
+		claims, err := ParseAccessToken(token)
+		if err != nil {
+			log.Println("❌ token parse error:", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if !claims.HasScope(ScopeMessagesRead) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_scope", "required_scope": ScopeMessagesRead})
+			return
+		}
+		userID := claims.UserID
+
+		// ✅ 所属している全ルームを購読対象にする
+		rooms, err := loadMemberRooms(db, userID)
+		if err != nil {
+			log.Println("❌ room_members fetch error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
+			return
+		}
+
+		// room_id 指定があればメンバーであることを確認
+		if roomIDStr := c.Query("room_id"); roomIDStr != "" {
+			roomID := parseUint(roomIDStr)
+			if !rooms[roomID] {
+				log.Printf("🚫 User %d tried to stream room %d without membership\n", userID, roomID)
+				c.JSON(http.StatusForbidden, notMemberFrame(roomID))
+				return
+			}
+		}
+
+		// ✅ 再開位置（Last-Event-ID を優先）
+		lastMessageID := c.GetHeader("Last-Event-ID")
+		if lastMessageID == "" {
+			lastMessageID = c.Query("last_message_id")
+		}
+		cursors, err := parseReplayCursors(lastMessageID, c.Query("cursors"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no") // nginx などのバッファリングを無効化
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		replayRooms := make(map[uint]bool, len(rooms))
+		for id := range rooms {
+			replayRooms[id] = true
+		}
+
+		// 接続登録（conn を持たないクライアントとしてハブに登録し、送信キューを自分で読む）
+		client := &Client{
+			hub:       hub,
+			userID:    userID,
+			sessionID: claims.SessionID,
+			rooms:     rooms,
+			send:      make(chan []byte, sendQueueSize),
+		}
+		hub.register <- client
+		presence.connected(userID)
+		log.Printf("📡 SSE client connected: user %d\n", userID)
+
+		defer func() {
+			hub.unregister <- client
+			presence.disconnected(userID)
+		}()
+
+		// ✅ 取りこぼしたイベントを先に送ってからライブ配信に切り替える
+		if len(cursors) > 0 {
+			client.markReplayed(replayMissedEvents(db, userID, replayRooms, cursors, func(payload interface{}) error {
+				data, err := json.Marshal(payload)
+				if err != nil {
+					return err
+				}
+				return writeSSE(c.Writer, data)
+			}))
+		}
+
+		// プロキシに切られないよう、定期的にコメント行を送る
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case data, ok := <-client.send:
+				if !ok {
+					// ハブに外された（遅いクライアントなど）
+					return
+				}
+				if client.skipReplayed(data) {
+					continue
+				}
+				if err := writeSSE(c.Writer, data); err != nil {
+					log.Println("❌ SSE write error:", err)
+					return
+				}
+			case <-ticker.C:
+				if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+					return
+				}
+				c.Writer.Flush()
+			}
+		}
+	}
+}
+
+// 1件のイベントを SSE 形式で書き込む
+func writeSSE(w gin.ResponseWriter, data []byte) error {
+	var head struct {
+		Type string `json:"type"`
+		ID   uint   `json:"id"`
+	}
+	json.Unmarshal(data, &head)
+
+	// メッセージ（スレッドの返信を含む）の ID を再接続時の Last-Event-ID にする
+	if (head.Type == "message" || head.Type == "thread_reply") && head.ID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", head.ID); err != nil {
+			return err
+		}
+	}
+	if head.Type != "" {
+		if _, err := fmt.Fprintf(w, "event: %s\n", head.Type); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWriteSSE(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"message has id", `{"type":"message","id":12}`, "id: 12\nevent: message\ndata: {\"type\":\"message\",\"id\":12}\n\n"},
+		{"thread reply has id", `{"type":"thread_reply","id":13}`, "id: 13\nevent: thread_reply\ndata: {\"type\":\"thread_reply\",\"id\":13}\n\n"},
+		{"other events have no id", `{"type":"read","id":12}`, "event: read\ndata: {\"type\":\"read\",\"id\":12}\n\n"},
+		{"message without id", `{"type":"message"}`, "event: message\ndata: {\"type\":\"message\"}\n\n"},
+		{"no type", `"hello"`, "data: \"hello\"\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			if err := writeSSE(c.Writer, []byte(tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	room := createTestRoom(t, alice.ID)
+	bobRoom := createTestRoom(t, bob.ID)
+	first := createTestMessage(t, room, alice.ID, "first")
+	missed := createTestMessage(t, room, alice.ID, "missed")
+
+	r := gin.New()
+	r.GET("/events", EventsHandler(testDB))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	token, err := generateAccessToken(alice.ID, "test-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		lastEventID string
+		wantStatus  int
+		wantID      uint // 最初に届くイベントの id
+	}{
+		{"missing token", "", "", http.StatusUnauthorized, 0},
+		{"invalid token", "?token=xxx", "", http.StatusUnauthorized, 0},
+		{"room without membership", fmt.Sprintf("?token=%s&room_id=%d", token, bobRoom), "", http.StatusForbidden, 0},
+		{"invalid cursors", "?token=" + token + "&cursors=x", "", http.StatusBadRequest, 0},
+		{"replays after Last-Event-ID", "?token=" + token, fmt.Sprint(first.ID), http.StatusOK, missed.ID},
+		{"replays after last_message_id", fmt.Sprintf("?token=%s&last_message_id=%d", token, first.ID), "", http.StatusOK, missed.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", server.URL+"/events"+tt.query, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantID == 0 {
+				return
+			}
+			if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			line, err := bufio.NewReader(res.Body).ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("id: %d", tt.wantID); strings.TrimSpace(line) != want {
+				t.Errorf("first line = %q, want %q", line, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"backend/database"
+	"backend/models"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ==============================
+// 🔹 テスト用の共通処理
+// ==============================
+// DB を使うテストは TEST_DATABASE_URL が設定されているときだけ実行する（未設定ならスキップ）
+//   例: TEST_DATABASE_URL="host=localhost user=user password=password dbname=chat_app_test port=5432 sslmode=disable" go test ./...
+// テストのたびに全テーブルを空にするので、開発用とは別の DB を指定すること
+
+// テストで作るテーブル（main.go の AutoMigrate と同じもの + 添付・メンション）
+var testModels = []interface{}{
+	&models.User{}, &models.Message{}, &models.ChatRoom{}, &models.RoomMember{}, &models.MessageRead{},
+	&models.RefreshToken{}, &models.RevokedToken{}, &models.Session{}, &models.RecoveryCode{},
+	&models.LoginThrottle{}, &models.AuditLog{}, &models.PersonalAccessToken{}, &models.MessageReaction{},
+	&models.MessageRevision{}, &models.Presence{}, &models.MessageAttachment{}, &models.Mention{},
+}
+
+var (
+	testDBOnce sync.Once
+	testDB     *gorm.DB
+	testDBErr  error
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// テスト用の DB に接続し、全テーブルを空にしてハンドラーから使えるようにする
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	testDBOnce.Do(func() {
+		testDB, testDBErr = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		if testDBErr != nil {
+			return
+		}
+		if testDBErr = testDB.AutoMigrate(testModels...); testDBErr != nil {
+			return
+		}
+		if testDBErr = database.MigrateSearch(testDB); testDBErr != nil {
+			return
+		}
+		SetDB(testDB)
+		// 共有のハブと、保存したメッセージの配信キューを動かす（動いていないと送信ハンドラーが止まる）
+		go StartHub()
+		go StartBroadcast()
+	})
+	if testDBErr != nil {
+		t.Fatal("test database:", testDBErr)
+	}
+
+	tables := make([]string, 0, len(testModels))
+	for _, m := range testModels {
+		stmt := &gorm.Statement{DB: testDB}
+		if err := stmt.Parse(m); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, stmt.Schema.Table)
+	}
+	if err := testDB.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE").Error; err != nil {
+		t.Fatal("truncate:", err)
+	}
+	return testDB
+}
+
+// テスト用の署名鍵（HS256）を使う
+func useTestKeys(t *testing.T) {
+	t.Helper()
+	ks, err := loadKeySet("", "", "test-secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := jwtKeys
+	jwtKeys = ks
+	t.Cleanup(func() { jwtKeys = prev })
+}
+
+func createTestUser(t *testing.T, username string) models.User {
+	t.Helper()
+	user := models.User{Username: username, PasswordHash: "x"}
+	if err := testDB.Create(&user).Error; err != nil {
+		t.Fatal("create user:", err)
+	}
+	return user
+}
+
+// ルームを作り、指定したユーザーをメンバーにする
+func createTestRoom(t *testing.T, memberIDs ...uint) uint {
+	t.Helper()
+	room := models.ChatRoom{IsGroup: len(memberIDs) > 2}
+	if err := testDB.Create(&room).Error; err != nil {
+		t.Fatal("create room:", err)
+	}
+	for _, userID := range memberIDs {
+		if err := testDB.Create(&models.RoomMember{RoomID: room.ID, UserID: userID, JoinedAt: time.Now()}).Error; err != nil {
+			t.Fatal("add member:", err)
+		}
+	}
+	return room.ID
+}
+
+func createTestMessage(t *testing.T, roomID, senderID uint, content string) models.Message {
+	t.Helper()
+	msg := models.Message{RoomID: roomID, SenderID: senderID, Content: content, CreatedAt: time.Now()}
+	if err := testDB.Create(&msg).Error; err != nil {
+		t.Fatal("create message:", err)
+	}
+	return msg
+}
+
+// AuthMiddleware の代わりに user_id を設定してハンドラーを呼び出す
+// route は main.go と同じパターン（例: /messages/:id）、path は実際のリクエスト先
+func serveAs(userID uint, method, route, path string, body interface{}, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(method, route, func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Next()
+	}, handler)
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// レスポンスの JSON を読む
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, out interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+}
+
+// ステータスコードを確認する
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d (%s), want %d %s", w.Code, w.Body.String(), want, http.StatusText(want))
+	}
+}
+
+// ログイン済みのセッションを作り、トークンの組を返す
+func loginTestSession(t *testing.T, userID uint, device string) tokenPair {
+	t.Helper()
+	sid, _ := randomToken(16)
+	if err := testDB.Create(&models.Session{ID: sid, UserID: userID, Device: device, LastUsedAt: time.Now()}).Error; err != nil {
+		t.Fatal("create session:", err)
+	}
+	pair, err := issueTokenPair(testDB, userID, sid)
+	if err != nil {
+		t.Fatal("issue tokens:", err)
+	}
+	return pair
+}
+
+// AuthMiddleware を通してハンドラーを呼び出す（token が空なら Authorization を付けない）
+// RequireScope などを挟むときは handlers に並べて渡す
+func serveWithToken(token, method, route, path string, body interface{}, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(method, route, append([]gin.HandlerFunc{AuthMiddleware()}, handlers...)...)
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
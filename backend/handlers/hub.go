@@ -0,0 +1,376 @@
+// handlers/hub.go
+package handlers
+
+import (
+	"backend/fanout"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 1クライアントあたりの送信キューの長さ（これを超えたら遅いクライアントとして切断）
+const sendQueueSize = 256
+
+// クライアントから受け取る1フレームの最大サイズ
+const maxFrameSize = 64 * 1024
+
+// ハートビート設定（環境変数で変更可能）
+var (
+	// 1回の書き込みに許す時間
+	writeWait = envDuration("WS_WRITE_WAIT", 10*time.Second)
+	// pong（または任意のフレーム）をこの時間受け取れなければ切断
+	pongWait = envDuration("WS_PONG_WAIT", 60*time.Second)
+	// ping の送信間隔（pongWait より短くする）
+	pingInterval = envDuration("WS_PING_INTERVAL", 50*time.Second)
+)
+
+func init() {
+	if pingInterval >= pongWait {
+		log.Printf("⚠️ WS_PING_INTERVAL (%s) must be shorter than WS_PONG_WAIT (%s), using %s\n",
+			pingInterval, pongWait, pongWait*9/10)
+		pingInterval = pongWait * 9 / 10
+	}
+}
+
+// リアルタイム配信のクライアント（1接続 = 1クライアント、複数ルームを購読できる）
+type Client struct {
+	hub       *Hub
+	conn      *websocket.Conn // SSE のクライアントでは nil
+	userID    uint
+	sessionID string        // 接続に使ったアクセストークンのセッション
+	rooms     map[uint]bool // 購読中のルーム（Run() のゴルーチンだけが触る）
+	send      chan []byte   // 送信キュー（書き込みは writePump だけが行う）
+
+	// 再接続時に再送したメッセージの ID と、ライブ配信との重複を捨てる期限（送信キューを読むゴルーチンだけが触る）
+	replayed      map[uint]bool
+	replayedUntil time.Time
+}
+
+// ルーム宛ての送信データ
+type roomPayload struct {
+	roomID     uint
+	exceptUser uint // 0 以外ならこのユーザーの接続には送らない
+	data       []byte
+}
+
+// 特定クライアント宛ての送信データ
+type clientPayload struct {
+	client *Client
+	data   []byte
+}
+
+// 複数ユーザー宛ての送信データ（ルームに関係ない通知）
+type usersPayload struct {
+	userIDs []uint
+	data    []byte
+}
+
+// 購読の追加・解除リクエスト
+// client が nil の場合は userID の全接続が対象
+type subscription struct {
+	client *Client
+	userID uint
+	roomID uint
+	join   bool
+}
+
+// 強制切断リクエスト（sessionID が空ならユーザーの全接続）
+type kickRequest struct {
+	userID    uint
+	sessionID string
+}
+
+// 接続中クライアントを管理するハブ
+// マップは Run() のゴルーチンだけが触るので、ロックは不要
+type Hub struct {
+	rooms      map[uint]map[*Client]bool // room_id → 購読中のクライアント
+	users      map[uint]map[*Client]bool // user_id → そのユーザーの接続
+	register   chan *Client
+	unregister chan *Client
+	subscribe  chan subscription
+	broadcast  chan roomPayload
+	direct     chan clientPayload
+	toUsers    chan usersPayload
+	kick       chan kickRequest
+	fanout     fanout.Backend // サーバー間の配信（自分宛ても含めてここ経由で届く）
+}
+
+func NewHub() *Hub {
+	h := &Hub{
+		rooms:      make(map[uint]map[*Client]bool),
+		users:      make(map[uint]map[*Client]bool),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		subscribe:  make(chan subscription),
+		broadcast:  make(chan roomPayload, sendQueueSize),
+		direct:     make(chan clientPayload, sendQueueSize),
+		toUsers:    make(chan usersPayload, sendQueueSize),
+		kick:       make(chan kickRequest),
+	}
+	h.SetFanout(fanout.NewMemory())
+	return h
+}
+
+// アプリ全体で共有するハブ
+var hub = NewHub()
+
+// 配信バックエンドを差し替える（main.go から、接続を受け付ける前に呼び出される）
+func SetFanout(b fanout.Backend) {
+	hub.SetFanout(b)
+}
+
+func (h *Hub) SetFanout(b fanout.Backend) {
+	b.Subscribe(h.dispatch)
+	h.fanout = b
+}
+
+// ハブのイベントループを起動（main.go から呼び出される）
+func StartHub() {
+	hub.Run()
+}
+
+func (h *Hub) Run() {
+	for {
+		select {
+		case client := <-h.register:
+			if h.users[client.userID] == nil {
+				h.users[client.userID] = make(map[*Client]bool)
+			}
+			h.users[client.userID][client] = true
+			wsActiveConnections.Add(1)
+			for roomID := range client.rooms {
+				h.join(client, roomID)
+			}
+			log.Printf("🔗 User %d connected (%d rooms)\n", client.userID, len(client.rooms))
+
+		case client := <-h.unregister:
+			h.remove(client)
+
+		case s := <-h.subscribe:
+			targets := map[*Client]bool{s.client: true}
+			if s.client == nil {
+				targets = h.users[s.userID]
+			}
+			for client := range targets {
+				if !h.users[client.userID][client] {
+					continue // 既に切断済み
+				}
+				if s.join {
+					h.join(client, s.roomID)
+				} else {
+					h.leave(client, s.roomID)
+				}
+			}
+
+		case p := <-h.broadcast:
+			for client := range h.rooms[p.roomID] {
+				if p.exceptUser != 0 && client.userID == p.exceptUser {
+					continue
+				}
+				select {
+				case client.send <- p.data:
+				default:
+					// キューが詰まっているクライアントは切断
+					h.evict(client)
+				}
+			}
+
+		case p := <-h.direct:
+			if !h.users[p.client.userID][p.client] {
+				continue // 既に切断済み
+			}
+			select {
+			case p.client.send <- p.data:
+			default:
+				h.evict(p.client)
+			}
+
+		case k := <-h.kick:
+			for client := range h.users[k.userID] {
+				if k.sessionID == "" || client.sessionID == k.sessionID {
+					log.Printf("🔒 Closing connection of revoked session: user %d\n", client.userID)
+					h.remove(client)
+				}
+			}
+
+		case p := <-h.toUsers:
+			for _, userID := range p.userIDs {
+				for client := range h.users[userID] {
+					select {
+					case client.send <- p.data:
+					default:
+						h.evict(client)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (h *Hub) join(client *Client, roomID uint) {
+	if h.rooms[roomID] == nil {
+		h.rooms[roomID] = make(map[*Client]bool)
+	}
+	h.rooms[roomID][client] = true
+	client.rooms[roomID] = true
+}
+
+func (h *Hub) leave(client *Client, roomID uint) {
+	delete(client.rooms, roomID)
+	if clients, ok := h.rooms[roomID]; ok {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(h.rooms, roomID)
+		}
+	}
+}
+
+// クライアントを管理対象から外し、送信キューを閉じる
+func (h *Hub) remove(client *Client) {
+	conns, ok := h.users[client.userID]
+	if !ok || !conns[client] {
+		return
+	}
+	for roomID := range client.rooms {
+		h.leave(client, roomID)
+	}
+	delete(conns, client)
+	if len(conns) == 0 {
+		delete(h.users, client.userID)
+	}
+	close(client.send)
+	wsActiveConnections.Add(-1)
+	log.Printf("👋 User %d disconnected\n", client.userID)
+}
+
+// 送信キューが詰まったクライアントを切断する
+func (h *Hub) evict(client *Client) {
+	log.Printf("🐢 Evicting slow client: user %d\n", client.userID)
+	wsEvictedClients.Add(1)
+	h.remove(client)
+}
+
+// バックエンドから届いたイベントを、このサーバーの接続に配る
+func (h *Hub) dispatch(ev fanout.Event) {
+	switch ev.Kind {
+	case fanout.KindRoom:
+		h.broadcast <- roomPayload{roomID: ev.RoomID, exceptUser: ev.ExceptUser, data: ev.Data}
+	case fanout.KindUsers:
+		h.toUsers <- usersPayload{userIDs: ev.UserIDs, data: ev.Data}
+	case fanout.KindSubscribe, fanout.KindUnsubscribe:
+		h.subscribe <- subscription{userID: ev.UserID, roomID: ev.RoomID, join: ev.Kind == fanout.KindSubscribe}
+	case fanout.KindDisconnect:
+		h.kick <- kickRequest{userID: ev.UserID, sessionID: ev.SessionID}
+	default:
+		log.Println("⚠️ Unknown fanout event:", ev.Kind)
+	}
+}
+
+// 全サーバーにイベントを送る
+func (h *Hub) publish(ev fanout.Event) {
+	if err := h.fanout.Publish(ev); err != nil {
+		log.Println("❌ fanout publish error:", err)
+	}
+}
+
+// 指定ルームの接続者全員に payload を JSON で送る
+func (h *Hub) BroadcastToRoom(roomID uint, payload interface{}) {
+	h.BroadcastToRoomExcept(roomID, 0, payload)
+}
+
+// 指定ルームの接続者のうち、exceptUser 以外に payload を送る（typing など）
+func (h *Hub) BroadcastToRoomExcept(roomID, exceptUser uint, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("❌ JSON marshal error:", err)
+		return
+	}
+	h.publish(fanout.Event{Kind: fanout.KindRoom, RoomID: roomID, ExceptUser: exceptUser, Data: data})
+}
+
+// このサーバーに接続している1つのクライアントにだけ payload を JSON で送る（エラーフレームなど）
+func (h *Hub) SendToClient(client *Client, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("❌ JSON marshal error:", err)
+		return
+	}
+	h.direct <- clientPayload{client: client, data: data}
+}
+
+// 指定ユーザーたちの全接続に payload を送る（presence など）
+func (h *Hub) SendToUsers(userIDs []uint, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("❌ JSON marshal error:", err)
+		return
+	}
+	h.publish(fanout.Event{Kind: fanout.KindUsers, UserIDs: userIDs, Data: data})
+}
+
+// ユーザーの全接続をルームに参加させる（メンバー追加時）
+func (h *Hub) SubscribeUser(userID, roomID uint) {
+	h.publish(fanout.Event{Kind: fanout.KindSubscribe, UserID: userID, RoomID: roomID})
+}
+
+// ユーザーの全接続をルームから外す（メンバー削除時）
+func (h *Hub) UnsubscribeUser(userID, roomID uint) {
+	h.publish(fanout.Event{Kind: fanout.KindUnsubscribe, UserID: userID, RoomID: roomID})
+}
+
+// セッションの接続をすべてのサーバーで切断する（ログアウト時）
+func (h *Hub) DisconnectSession(userID uint, sessionID string) {
+	h.publish(fanout.Event{Kind: fanout.KindDisconnect, UserID: userID, SessionID: sessionID})
+}
+
+// ユーザーの接続をすべてのサーバーで切断する
+func (h *Hub) DisconnectUser(userID uint) {
+	h.publish(fanout.Event{Kind: fanout.KindDisconnect, UserID: userID})
+}
+
+// 送信キューの内容をソケットに書き込み、定期的に ping を送る（1クライアントにつき1ゴルーチン）
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// ハブにキューを閉じられた
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if c.skipReplayed(data) {
+				continue
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Println("❌ Write error:", err)
+				c.abort()
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("💀 Reaping dead connection: user %d (ping failed: %v)\n", c.userID, err)
+				wsReapedConnections.Add(1)
+				c.abort()
+				return
+			}
+		}
+	}
+}
+
+// 接続を閉じて読み込みループも終わらせ、ハブがキューを閉じるまで読み捨てる
+func (c *Client) abort() {
+	c.conn.Close()
+	for range c.send {
+	}
+}
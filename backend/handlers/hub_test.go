@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// テスト用に起動したハブ（テストごとに作り、他のテストと混ざらないようにする）
+func startTestHub(t *testing.T) *Hub {
+	t.Helper()
+	h := NewHub()
+	go h.Run()
+	return h
+}
+
+// ソケットを持たないクライアントを登録する（送信キューを直接読む）
+func connectTestClient(h *Hub, userID uint, rooms ...uint) *Client {
+	client := &Client{
+		hub:    h,
+		userID: userID,
+		rooms:  make(map[uint]bool),
+		send:   make(chan []byte, sendQueueSize),
+	}
+	for _, roomID := range rooms {
+		client.rooms[roomID] = true
+	}
+	h.register <- client
+	return client
+}
+
+// 送信キューから1件読む（閉じられていたら ok = false）
+func receiveFrame(t *testing.T, client *Client) (string, bool) {
+	t.Helper()
+	select {
+	case data, ok := <-client.send:
+		return string(data), ok
+	case <-time.After(time.Second):
+		t.Fatalf("user %d: no frame received", client.userID)
+		return "", false
+	}
+}
+
+// flushRoom に区切りを送り、それより前に届いたフレームを返す
+// 同じ broadcast キューを通るので、区切りより前のフレームはすべて届いている
+// 区切りは他のクライアントにも届くので、呼び出しごとに番号を付けて他の呼び出しの区切りは読み飛ばす
+const flushRoom = 999
+
+var flushSeq atomic.Int64
+
+func framesUntilFlush(t *testing.T, h *Hub, client *Client) []string {
+	t.Helper()
+	marker := fmt.Sprintf("flush-%d", flushSeq.Add(1))
+	h.BroadcastToRoom(flushRoom, marker)
+
+	var frames []string
+	for {
+		data, ok := receiveFrame(t, client)
+		if !ok {
+			t.Fatalf("user %d: send queue closed", client.userID)
+		}
+		if data == `"`+marker+`"` {
+			return frames
+		}
+		if !strings.HasPrefix(data, `"flush-`) {
+			frames = append(frames, data)
+		}
+	}
+}
+
+func TestHubBroadcastToRoom(t *testing.T) {
+	tests := []struct {
+		name       string
+		room       uint
+		exceptUser uint
+		want       map[uint]bool // user_id → 受け取るか
+	}{
+		{"room members receive", 1, 0, map[uint]bool{1: true, 2: true, 3: false}},
+		{"other room", 2, 0, map[uint]bool{1: false, 2: true, 3: true}},
+		{"except sender", 1, 1, map[uint]bool{1: false, 2: true, 3: false}},
+		{"nobody subscribed", 3, 0, map[uint]bool{1: false, 2: false, 3: false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := startTestHub(t)
+			clients := []*Client{
+				connectTestClient(h, 1, 1, flushRoom),
+				connectTestClient(h, 2, 1, 2, flushRoom),
+				connectTestClient(h, 3, 2, flushRoom),
+			}
+
+			h.BroadcastToRoomExcept(tt.room, tt.exceptUser, "hello")
+
+			for _, client := range clients {
+				frames := framesUntilFlush(t, h, client)
+				got := len(frames) == 1 && frames[0] == `"hello"`
+				if got != tt.want[client.userID] || len(frames) > 1 {
+					t.Errorf("user %d: frames = %v, want received = %v", client.userID, frames, tt.want[client.userID])
+				}
+			}
+		})
+	}
+}
+
+func TestHubSendToUsersReachesEveryConnection(t *testing.T) {
+	h := startTestHub(t)
+	laptop := connectTestClient(h, 1, flushRoom)
+	phone := connectTestClient(h, 1, flushRoom)
+	other := connectTestClient(h, 2, flushRoom)
+
+	h.SendToUsers([]uint{1}, "presence")
+	// toUsers と broadcast は別のキューなので、先に届くのを待ってから区切りを送る
+	for _, client := range []*Client{laptop, phone} {
+		if data, _ := receiveFrame(t, client); data != `"presence"` {
+			t.Errorf("connection of user 1 got %s, want presence", data)
+		}
+	}
+	if frames := framesUntilFlush(t, h, other); len(frames) != 0 {
+		t.Errorf("user 2 got %v, want nothing", frames)
+	}
+}
+
+func TestHubEvictsSlowClient(t *testing.T) {
+	h := startTestHub(t)
+	slow := &Client{hub: h, userID: 1, rooms: map[uint]bool{1: true}, send: make(chan []byte)}
+	h.register <- slow
+	fast := connectTestClient(h, 2, 1, flushRoom)
+
+	h.BroadcastToRoom(1, "hello")
+
+	if frames := framesUntilFlush(t, h, fast); len(frames) != 1 {
+		t.Errorf("fast client got %v, want one frame", frames)
+	}
+	// キューが詰まったクライアントは切断され、送信キューが閉じられる
+	select {
+	case _, ok := <-slow.send:
+		if ok {
+			t.Error("slow client received a frame, want closed queue")
+		}
+	case <-time.After(time.Second):
+		t.Error("slow client was not evicted")
+	}
+}
+
+func TestHubDisconnectSession(t *testing.T) {
+	tests := []struct {
+		name      string
+		sessionID string
+		wantOpen  map[string]bool // session_id → 接続が残るか
+	}{
+		{"one session", "a", map[string]bool{"a": false, "b": true}},
+		{"all sessions", "", map[string]bool{"a": false, "b": false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := startTestHub(t)
+			clients := map[string]*Client{}
+			for _, sid := range []string{"a", "b"} {
+				client := &Client{hub: h, userID: 1, sessionID: sid, rooms: map[uint]bool{flushRoom: true}, send: make(chan []byte, sendQueueSize)}
+				h.register <- client
+				clients[sid] = client
+			}
+
+			if tt.sessionID == "" {
+				h.DisconnectUser(1)
+			} else {
+				h.DisconnectSession(1, tt.sessionID)
+			}
+			h.BroadcastToRoom(flushRoom, "flush-kick")
+
+			for sid, client := range clients {
+				_, open := receiveFrame(t, client)
+				if open != tt.wantOpen[sid] {
+					t.Errorf("session %s: open = %v, want %v", sid, open, tt.wantOpen[sid])
+				}
+			}
+		})
+	}
+}
+
+// writePump だけを動かすサーバーに接続し、サーバー側のクライアントと接続を返す
+func startWritePump(t *testing.T) (*Client, *websocket.Conn) {
+	t.Helper()
+	clients := make(chan *Client, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := &Client{conn: conn, userID: 1, rooms: map[uint]bool{}, send: make(chan []byte, sendQueueSize)}
+		clients <- client
+		client.writePump()
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return <-clients, conn
+}
+
+func TestWritePump(t *testing.T) {
+	prev := pingInterval
+	pingInterval = 20 * time.Millisecond
+	t.Cleanup(func() { pingInterval = prev })
+
+	t.Run("sends pings periodically", func(t *testing.T) {
+		_, conn := startWritePump(t)
+		pings := make(chan struct{}, 10)
+		conn.SetPingHandler(func(string) error {
+			pings <- struct{}{}
+			return nil
+		})
+		go conn.ReadMessage() // 制御フレームは読み込み中に処理される
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-pings:
+			case <-time.After(time.Second):
+				t.Fatalf("ping %d not received", i+1)
+			}
+		}
+	})
+
+	tests := []struct {
+		name      string
+		frames    []string
+		wantClose bool
+	}{
+		{"delivers queued frames in order", []string{`{"n":1}`, `{"n":2}`}, false},
+		{"closing the queue closes the socket", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, conn := startWritePump(t)
+			for _, f := range tt.frames {
+				client.send <- []byte(f)
+			}
+			if tt.wantClose {
+				close(client.send)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			for _, want := range tt.frames {
+				_, data, err := conn.ReadMessage()
+				if err != nil || string(data) != want {
+					t.Fatalf("read = %s, %v; want %s", data, err, want)
+				}
+			}
+			if tt.wantClose {
+				if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNoStatusReceived) {
+					t.Errorf("read after close = %v, want close frame", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// handlers/keys.go
+package handlers
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ==============================
+// 🔹 JWT 署名鍵の設定
+// ==============================
+// - JWT_KEYS_DIR   : 鍵ファイルのディレクトリ。ファイル名（拡張子なし）が kid になる
+//   - <kid>.pem     … 秘密鍵（PKCS#8 / PKCS#1 の RSA、または Ed25519）。署名と検証に使う
+//   - <kid>.pub.pem … 公開鍵のみ。ローテーションで退役した鍵の検証用
+// - JWT_ACTIVE_KID : 署名に使う鍵の kid（秘密鍵が1つだけなら省略可）
+// - JWT_SECRET     : HS256 の共有鍵（kid = "default"）。JWT_KEYS_DIR がなければこれで署名する
+//
+// - JWT_ALLOW_DEV_SECRET : "true" のときだけ、どれも設定されていなければ開発用の固定鍵（HS256）を使う
+//
+// 鍵が設定されておらず JWT_ALLOW_DEV_SECRET も指定されていなければ起動しない
+
+// 開発用の固定鍵（本番では JWT_KEYS_DIR か JWT_SECRET を設定すること）
+const devJWTSecret = "super-secret-key"
+
+// kid が付いていない（鍵ローテーション導入前の）トークンを検証する鍵
+const legacyKID = "default"
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey // 検証専用の鍵では nil
+	public  crypto.PublicKey  // HS256 では共有鍵そのもの
+}
+
+type keySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+var jwtKeys *keySet
+
+// 環境変数から署名鍵を読み込む（main.go の起動時に呼び出す。失敗したら起動しない）
+func LoadJWTKeys() {
+	ks, err := loadKeySet(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_ACTIVE_KID"), os.Getenv("JWT_SECRET"),
+		os.Getenv("JWT_ALLOW_DEV_SECRET") == "true")
+	if err != nil {
+		log.Fatal("❌ JWT鍵の読み込みに失敗:", err)
+	}
+	log.Printf("🔑 JWT signing key: kid=%s alg=%s\n", ks.active.kid, ks.active.method.Alg())
+	jwtKeys = ks
+}
+
+func loadKeySet(dir, activeKID, secret string, allowDevSecret bool) (*keySet, error) {
+	ks := &keySet{keys: make(map[string]*signingKey)}
+
+	if secret == "" && dir == "" {
+		if !allowDevSecret {
+			return nil, errors.New("JWT_KEYS_DIR or JWT_SECRET is required (set JWT_ALLOW_DEV_SECRET=true to use the development key)")
+		}
+		log.Println("🚨🚨🚨 WARNING: JWT_KEYS_DIR / JWT_SECRET が未設定のため開発用の固定鍵で署名します。誰でもトークンを偽造できるので本番では絶対に使わないこと 🚨🚨🚨")
+		secret = devJWTSecret
+	}
+	if secret != "" {
+		ks.keys[legacyKID] = &signingKey{
+			kid:     legacyKID,
+			method:  jwt.SigningMethodHS256,
+			private: []byte(secret),
+			public:  []byte(secret),
+		}
+	}
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			key, err := loadPEMKey(file)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			if _, dup := ks.keys[key.kid]; dup {
+				return nil, fmt.Errorf("duplicate kid %q", key.kid)
+			}
+			ks.keys[key.kid] = key
+		}
+	}
+
+	// 署名に使う鍵を決める
+	switch {
+	case activeKID != "":
+		ks.active = ks.keys[activeKID]
+		if ks.active == nil || ks.active.private == nil {
+			return nil, fmt.Errorf("JWT_ACTIVE_KID %q has no private key", activeKID)
+		}
+	case dir != "":
+		var candidates []*signingKey
+		for _, k := range ks.keys {
+			if k.private != nil && k.kid != legacyKID {
+				candidates = append(candidates, k)
+			}
+		}
+		if len(candidates) != 1 {
+			return nil, errors.New("JWT_ACTIVE_KID is required when JWT_KEYS_DIR has zero or several private keys")
+		}
+		ks.active = candidates[0]
+	default:
+		ks.active = ks.keys[legacyKID]
+	}
+
+	return ks, nil
+}
+
+// PEM ファイルから鍵を読む（kid はファイル名）
+func loadPEMKey(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+
+	name := filepath.Base(file)
+	if strings.HasSuffix(name, ".pub.pem") {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newSigningKey(strings.TrimSuffix(name, ".pub.pem"), nil, pub)
+	}
+
+	kid := strings.TrimSuffix(name, ".pem")
+	if priv, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key")
+		}
+		return newSigningKey(kid, priv, signer.Public())
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("unsupported private key")
+	}
+	return newSigningKey(kid, priv, &priv.PublicKey)
+}
+
+func newSigningKey(kid string, priv crypto.PrivateKey, pub crypto.PublicKey) (*signingKey, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: priv, public: pub}, nil
+	case ed25519.PublicKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: priv, public: pub}, nil
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+}
+
+// 署名に使っている鍵で claims に署名する（ヘッダーに kid を付ける）
+func (ks *keySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	token.Header["kid"] = ks.active.kid
+	return token.SignedString(ks.active.private)
+}
+
+// jwt.Parse に渡す鍵の取得関数（kid とアルゴリズムが一致する鍵だけを使う）
+func (ks *keySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = legacyKID
+	}
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown kid")
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.public, nil
+}
+
+// ==============================
+// 🔹 公開鍵の配布（JWKS）
+// ==============================
+// - リクエスト: GET /.well-known/jwks.json
+// - 呼び出し元: アクセストークンを検証する社内の他サービス
+// - 処理: RS256 / EdDSA の公開鍵を JWK 形式で返す（HS256 の共有鍵は公開しない）
+func JWKSHandler(c *gin.Context) {
+	keys := []gin.H{}
+
+	kids := make([]string, 0, len(jwtKeys.keys))
+	for kid := range jwtKeys.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	for _, kid := range kids {
+		key := jwtKeys.keys[kid]
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, gin.H{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": key.method.Alg(),
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, gin.H{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"use": "sig",
+				"alg": key.method.Alg(),
+				"kid": kid,
+				"x":   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DER を PEM にしてファイルに書き出す
+func writeTestPEM(t *testing.T, dir, name, typ string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// RSA（PKCS#1）と Ed25519（PKCS#8）の秘密鍵、退役した Ed25519 の公開鍵を置いたディレクトリ
+func testKeysDir(t *testing.T) (dir string, retired ed25519.PrivateKey) {
+	t.Helper()
+	dir = t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestPEM(t, dir, "rsa-1.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	writeTestPEM(t, dir, "ed-2.pem", "PRIVATE KEY", der)
+
+	retiredPub, retired, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ = x509.MarshalPKIXPublicKey(retiredPub)
+	writeTestPEM(t, dir, "ed-0.pub.pem", "PUBLIC KEY", der)
+	return dir, retired
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir, _ := testKeysDir(t)
+	single := t.TempDir()
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	writeTestPEM(t, single, "only.pem", "PRIVATE KEY", der)
+	broken := t.TempDir()
+	os.WriteFile(filepath.Join(broken, "bad.pem"), []byte("not a key"), 0o600)
+
+	tests := []struct {
+		name       string
+		dir        string
+		activeKID  string
+		secret     string
+		allowDev   bool
+		wantErr    bool
+		wantActive string
+		wantAlg    string
+	}{
+		{"nothing configured", "", "", "", false, true, "", ""},
+		{"development key allowed", "", "", "", true, false, legacyKID, "HS256"},
+		{"shared secret", "", "", "s3cret", false, false, legacyKID, "HS256"},
+		{"active RSA key", dir, "rsa-1", "", false, false, "rsa-1", "RS256"},
+		{"active Ed25519 key", dir, "ed-2", "", false, false, "ed-2", "EdDSA"},
+		{"several private keys without active kid", dir, "", "", false, true, "", ""},
+		{"single private key", single, "", "", false, false, "only", "EdDSA"},
+		{"active kid is a public key", dir, "ed-0", "", false, true, "", ""},
+		{"unknown active kid", dir, "nope", "", false, true, "", ""},
+		{"broken PEM file", broken, "", "", false, true, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := loadKeySet(tt.dir, tt.activeKID, tt.secret, tt.allowDev)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if ks.active.kid != tt.wantActive || ks.active.method.Alg() != tt.wantAlg {
+				t.Errorf("active = %s/%s, want %s/%s", ks.active.kid, ks.active.method.Alg(), tt.wantActive, tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestKeySetVerifiesAcrossRotation(t *testing.T) {
+	dir, retired := testKeysDir(t)
+	before, err := loadKeySet(dir, "rsa-1", "legacy-secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := loadKeySet(dir, "ed-2", "legacy-secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Minute).Unix()}
+	signedBefore, _ := before.sign(claims)
+	signedAfter, _ := after.sign(claims)
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("legacy-secret"))
+
+	byRetired := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	byRetired.Header["kid"] = "ed-0"
+	signedRetired, _ := byRetired.SignedString(retired)
+
+	unknownKID := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	unknownKID.Header["kid"] = "missing"
+	signedUnknown, _ := unknownKID.SignedString(retired)
+
+	// kid の鍵と違うアルゴリズム（RSA 鍵の kid で HS256 署名）
+	wrongAlg := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	wrongAlg.Header["kid"] = "rsa-1"
+	signedWrongAlg, _ := wrongAlg.SignedString([]byte("legacy-secret"))
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"signed before rotation", signedBefore, true},
+		{"signed after rotation", signedAfter, true},
+		{"retired key (public only)", signedRetired, true},
+		{"legacy token without kid", legacy, true},
+		{"unknown kid", signedUnknown, false},
+		{"algorithm does not match kid", signedWrongAlg, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := jwt.Parse(tt.token, after.keyFunc)
+			if valid := err == nil && token.Valid; valid != tt.valid {
+				t.Errorf("valid = %v (%v), want %v", valid, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	dir, _ := testKeysDir(t)
+	ks, err := loadKeySet(dir, "rsa-1", "shared-secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := jwtKeys
+	jwtKeys = ks
+	t.Cleanup(func() { jwtKeys = prev })
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	JWKSHandler(c)
+	expectStatus(t, w, http.StatusOK)
+
+	var body struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Alg string `json:"alg"`
+		} `json:"keys"`
+	}
+	decodeBody(t, w, &body)
+
+	// HS256 の共有鍵は公開しない
+	want := map[string]string{"ed-0": "OKP/EdDSA", "ed-2": "OKP/EdDSA", "rsa-1": "RSA/RS256"}
+	if len(body.Keys) != len(want) {
+		t.Fatalf("keys = %+v, want %v", body.Keys, want)
+	}
+	for _, k := range body.Keys {
+		if want[k.Kid] != k.Kty+"/"+k.Alg {
+			t.Errorf("key %s = %s/%s, want %s", k.Kid, k.Kty, k.Alg, want[k.Kid])
+		}
+	}
+}
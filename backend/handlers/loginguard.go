@@ -0,0 +1,153 @@
+// handlers/loginguard.go
+package handlers
+
+import (
+	"backend/models"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ログイン失敗の扱い（DB に保存するので複数サーバーでも共有される）
+//   - 一定回数までは自由に再試行できる
+//   - それを超えると、失敗するたびに待ち時間が倍になる（1秒, 2秒, 4秒, ...）
+//   - さらに失敗が続くとロックし、ロック期間が過ぎるまでパスワードを確認しない
+//
+// 最後の失敗から loginAttemptWindow が過ぎたら回数はリセットされる
+var (
+	loginLockoutDuration = envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+	loginAttemptWindow   = envDuration("LOGIN_ATTEMPT_WINDOW", 15*time.Minute)
+)
+
+// 失敗回数のしきい値
+type loginLimit struct {
+	free    int // この回数までは待ち時間なし
+	lockout int // この回数に達したらロック
+}
+
+var (
+	accountLoginLimit = loginLimit{free: 3, lockout: 10}
+	ipLoginLimit      = loginLimit{free: 10, lockout: 50}
+)
+
+type loginGuard struct{}
+
+var loginGuards = &loginGuard{}
+
+// 失敗回数のキー（存在しないユーザー名でも同じように数える）
+func accountKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// いずれかのキーが待ち時間中・ロック中なら、残り時間を返す
+func (g *loginGuard) blocked(keys ...string) time.Duration {
+	var throttles []models.LoginThrottle
+	if err := db.Where("key IN ? AND locked_until > ?", keys, time.Now()).Find(&throttles).Error; err != nil {
+		log.Println("❌ login throttle check error:", err)
+		return 0
+	}
+
+	var wait time.Duration
+	for _, t := range throttles {
+		if d := time.Until(*t.LockedUntil); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// 失敗を記録し、回数に応じて待ち時間・ロックを設定する
+// ロックした（回数がちょうどしきい値に達した）ときは true
+func (g *loginGuard) fail(key string, limit loginLimit) (bool, error) {
+	now := time.Now()
+
+	// 同時に失敗しても取りこぼさないよう、1文で加算する
+	var failures int
+	if err := db.Raw(`
+		INSERT INTO login_throttles (key, failures, last_failure_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures`,
+		key, now, now.Add(-loginAttemptWindow)).Scan(&failures).Error; err != nil {
+		return false, err
+	}
+
+	var wait time.Duration
+	switch {
+	case failures >= limit.lockout:
+		wait = loginLockoutDuration
+	case failures > limit.free:
+		// シフトが大きすぎるとあふれるので、上限を超える分はロック期間にそろえる
+		wait = loginLockoutDuration
+		if shift := failures - limit.free - 1; shift < 30 && time.Second<<shift < wait {
+			wait = time.Second << shift
+		}
+	default:
+		return false, nil
+	}
+
+	until := now.Add(wait)
+	if err := db.Model(&models.LoginThrottle{}).
+		Where("key = ?", key).
+		Update("locked_until", until).Error; err != nil {
+		return false, err
+	}
+	return failures == limit.lockout, nil
+}
+
+// ログイン失敗を記録する（アカウントと IP の両方）
+// userID はユーザーが存在する場合だけ渡す（監査ログ用）
+func (g *loginGuard) recordFailure(username, ip string, userID *uint) {
+	for _, t := range []struct {
+		key   string
+		limit loginLimit
+		what  string
+	}{
+		{accountKey(username), accountLoginLimit, "account"},
+		{ipKey(ip), ipLoginLimit, "ip"},
+	} {
+		locked, err := g.fail(t.key, t.limit)
+		if err != nil {
+			log.Println("❌ login throttle update error:", err)
+			continue
+		}
+		if locked {
+			log.Printf("🔒 Login locked (%s): username=%q ip=%s\n", t.what, username, ip)
+			audit("login_locked", userID, username, ip,
+				fmt.Sprintf("%s locked for %s after %d failed attempts", t.what, loginLockoutDuration, t.limit.lockout))
+		}
+	}
+
+	// 期限が過ぎて意味のなくなった行を掃除
+	now := time.Now()
+	db.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", now.Add(-loginAttemptWindow), now).
+		Delete(&models.LoginThrottle{})
+}
+
+// ログイン成功でアカウントの失敗回数をリセットする
+// （IP の回数はリセットしない。1つの正しいアカウントで他のアカウントへの総当たりを続けられないように）
+func (g *loginGuard) recordSuccess(username string) {
+	if err := db.Where("key = ?", accountKey(username)).Delete(&models.LoginThrottle{}).Error; err != nil {
+		log.Println("❌ login throttle reset error:", err)
+	}
+}
+
+// 監査ログに記録する（失敗してもリクエストは止めない）
+func audit(event string, userID *uint, username, ip, detail string) {
+	if err := db.Create(&models.AuditLog{
+		Event:    event,
+		UserID:   userID,
+		Username: username,
+		IP:       ip,
+		Detail:   detail,
+	}).Error; err != nil {
+		log.Println("❌ audit log error:", err)
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"backend/models"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginGuardKeys(t *testing.T) {
+	tests := []struct {
+		got  string
+		want string
+	}{
+		{accountKey("alice"), "user:alice"},
+		{accountKey("  Alice "), "user:alice"},
+		{accountKey("no-such-user"), "user:no-such-user"},
+		{ipKey("192.0.2.1"), "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("key = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+	setupTestDB(t)
+	limit := loginLimit{free: 2, lockout: 5}
+
+	// 失敗の回数ごとの待ち時間（free を超えると倍々、lockout でロック）
+	tests := []struct {
+		failures   int
+		wantWait   time.Duration
+		wantLocked bool
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{5, loginLockoutDuration, true},
+		{6, loginLockoutDuration, false}, // ロックの記録は達したときの1回だけ
+	}
+	for _, tt := range tests {
+		locked, err := loginGuards.fail("user:alice", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != tt.wantLocked {
+			t.Errorf("failure %d: locked = %v, want %v", tt.failures, locked, tt.wantLocked)
+		}
+		wait := loginGuards.blocked("user:alice")
+		if wait > tt.wantWait || wait < tt.wantWait-time.Second {
+			t.Errorf("failure %d: wait = %v, want about %v", tt.failures, wait, tt.wantWait)
+		}
+	}
+
+	// 最後の失敗から loginAttemptWindow が過ぎたら数え直す
+	testDB.Model(&models.LoginThrottle{}).Where("key = ?", "user:alice").
+		UpdateColumn("last_failure_at", time.Now().Add(-loginAttemptWindow-time.Minute))
+	loginGuards.fail("user:alice", limit)
+	var throttle models.LoginThrottle
+	testDB.First(&throttle, "key = ?", "user:alice")
+	if throttle.Failures != 1 {
+		t.Errorf("failures after the window = %d, want 1", throttle.Failures)
+	}
+}
+
+func TestLoginHandlerThrottle(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	alice := createTestUser(t, "alice")
+	hash, _ := bcrypt.GenerateFromPassword([]byte("C0rrect-pass"), bcrypt.MinCost)
+	testDB.Model(&alice).Update("password_hash", string(hash))
+
+	login := func(username, password string) (int, string) {
+		body := map[string]string{"username": username, "password": password}
+		w := serveAs(0, "POST", "/login", "/login", body, LoginHandler)
+		return w.Code, w.Header().Get("Retry-After")
+	}
+
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		wantStatus int
+	}{
+		{"wrong password 1", "alice", "wrong", http.StatusUnauthorized},
+		{"unknown user counts the same", "nobody", "wrong", http.StatusUnauthorized},
+		{"wrong password 2", "alice", "wrong", http.StatusUnauthorized},
+		{"wrong password 3", "alice", "wrong", http.StatusUnauthorized},
+		{"wrong password 4 starts the backoff", "Alice", "wrong", http.StatusUnauthorized},
+		{"correct password while waiting", "alice", "C0rrect-pass", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, retryAfter := login(tt.username, tt.password)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if (retryAfter != "") != (status == http.StatusTooManyRequests) {
+				t.Errorf("Retry-After = %q", retryAfter)
+			}
+		})
+	}
+
+	// 待ち時間が過ぎれば正しいパスワードでログインでき、アカウントの回数はリセットされる
+	testDB.Model(&models.LoginThrottle{}).Where("1 = 1").UpdateColumn("locked_until", time.Now().Add(-time.Second))
+	if status, _ := login("alice", "C0rrect-pass"); status != http.StatusOK {
+		t.Fatalf("login after the wait: status = %d, want 200", status)
+	}
+	var count int64
+	testDB.Model(&models.LoginThrottle{}).Where("key = ?", accountKey("alice")).Count(&count)
+	if count != 0 {
+		t.Error("account failures were not reset")
+	}
+	// IP の回数はリセットしない
+	testDB.Model(&models.LoginThrottle{}).Where("key LIKE ?", "ip:%").Count(&count)
+	if count != 1 {
+		t.Error("ip failures were reset")
+	}
+
+	// ロックしたときは監査ログに残す
+	testDB.Create(&models.LoginThrottle{Key: accountKey("alice"), Failures: accountLoginLimit.lockout - 1, LastFailureAt: time.Now()})
+	login("alice", "wrong")
+	var logs []models.AuditLog
+	testDB.Where("event = ?", "login_locked").Find(&logs)
+	if len(logs) != 1 || logs[0].UserID == nil || *logs[0].UserID != alice.ID {
+		t.Errorf("audit logs = %+v, want one login_locked for alice", logs)
+	}
+}
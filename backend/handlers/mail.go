@@ -0,0 +1,107 @@
+// handlers/mail.go
+package handlers
+
+import (
+	"backend/mailer"
+	"backend/models"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	emailVerifyTokenType   = "email_verify"
+	passwordResetTokenType = "password_reset"
+)
+
+// メール内リンクの有効期限（環境変数で変更可能）
+var (
+	emailVerifyTTL   = envDuration("EMAIL_VERIFY_TTL", 24*time.Hour)
+	passwordResetTTL = envDuration("PASSWORD_RESET_TTL", time.Hour)
+)
+
+// メールのリンク先（フロントエンドの URL）
+var appURL = strings.TrimRight(envString("APP_URL", "http://localhost:3001"), "/")
+
+// メール送信の実装（main.go から差し替える。既定は送らずにメモリに保持するだけ）
+var mailSender mailer.Mailer = mailer.NewMemory()
+
+// メール送信の実装を差し替える（main.go から呼び出される）
+func SetMailer(m mailer.Mailer) {
+	mailSender = m
+}
+
+// メールアドレスの表記ゆれ（前後の空白・大文字）をそろえる
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// パスワードハッシュの指紋（パスワードが変わると再設定リンクが使えなくなる）
+func passwordFingerprint(passwordHash string) string {
+	return hashToken(passwordHash)[:16]
+}
+
+// レスポンスを待たせないよう、送信はバックグラウンドで行う
+func deliver(msg mailer.Message) {
+	go func() {
+		if err := mailSender.Send(msg); err != nil {
+			log.Printf("❌ mail send error (to %s): %v\n", msg.To, err)
+		}
+	}()
+}
+
+// メールアドレス確認のリンクを送る（登録時用。送信はバックグラウンドで行い、失敗はログにだけ残る）
+func sendVerificationEmail(user models.User) error {
+	msg, err := verificationEmail(user)
+	if err != nil {
+		return err
+	}
+	deliver(msg)
+	return nil
+}
+
+// メールアドレス確認のメール
+func verificationEmail(user models.User) (mailer.Message, error) {
+	token, err := issuePurposeToken(emailVerifyTokenType, user.ID, emailVerifyTTL, jwt.MapClaims{"email": user.Email})
+	if err != nil {
+		return mailer.Message{}, err
+	}
+	link := appURL + "/verify-email?token=" + url.QueryEscape(token)
+
+	return mailer.Message{
+		To:      user.Email,
+		Subject: "【chat_app】メールアドレスの確認",
+		Body: user.Username + " さん\n\n" +
+			"chat_app へのご登録ありがとうございます。\n" +
+			"以下のリンクを開いて、メールアドレスの確認を完了してください。\n\n" +
+			link + "\n\n" +
+			"このリンクの有効期限は " + emailVerifyTTL.String() + " です。\n" +
+			"心当たりがない場合は、このメールを破棄してください。\n",
+	}, nil
+}
+
+// パスワード再設定のリンクを送る
+func sendPasswordResetEmail(user models.User) error {
+	token, err := issuePurposeToken(passwordResetTokenType, user.ID, passwordResetTTL, jwt.MapClaims{
+		"pwh": passwordFingerprint(user.PasswordHash),
+	})
+	if err != nil {
+		return err
+	}
+	link := appURL + "/reset-password?token=" + url.QueryEscape(token)
+
+	deliver(mailer.Message{
+		To:      user.Email,
+		Subject: "【chat_app】パスワードの再設定",
+		Body: user.Username + " さん\n\n" +
+			"パスワード再設定のリクエストを受け付けました。\n" +
+			"以下のリンクから新しいパスワードを設定してください。\n\n" +
+			link + "\n\n" +
+			"このリンクの有効期限は " + passwordResetTTL.String() + " です。\n" +
+			"心当たりがない場合は、このメールを破棄してください（パスワードは変更されません）。\n",
+	})
+	return nil
+}
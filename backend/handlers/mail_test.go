@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"backend/mailer"
+	"backend/models"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"alice@example.com", "alice@example.com"},
+		{"  Alice@Example.COM ", "alice@example.com"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeEmail(tt.email); got != tt.want {
+			t.Errorf("normalizeEmail(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestPasswordFingerprint(t *testing.T) {
+	a := passwordFingerprint("$2a$10$first")
+	if len(a) != 16 {
+		t.Errorf("length = %d, want 16", len(a))
+	}
+	if a != passwordFingerprint("$2a$10$first") {
+		t.Error("fingerprint is not stable")
+	}
+	if a == passwordFingerprint("$2a$10$second") {
+		t.Error("fingerprint did not change with the password")
+	}
+}
+
+// 送信先をメモリに差し替える
+func useTestMailer(t *testing.T) *mailer.Memory {
+	t.Helper()
+	m := mailer.NewMemory()
+	prev := mailSender
+	mailSender = m
+	t.Cleanup(func() { mailSender = prev })
+	return m
+}
+
+// 送信はバックグラウンドなので、n 通届くまで待つ
+func waitForMail(t *testing.T, m *mailer.Memory, n int) []mailer.Message {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(m.Sent()) < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return m.Sent()
+}
+
+var mailTokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+// メール本文のリンクからトークンを取り出す
+func tokenFromMail(t *testing.T, msg mailer.Message) string {
+	t.Helper()
+	match := mailTokenPattern.FindStringSubmatch(msg.Body)
+	if match == nil {
+		t.Fatalf("no link in mail: %q", msg.Body)
+	}
+	token, _ := url.QueryUnescape(match[1])
+	return token
+}
+
+func TestVerifyEmail(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	m := useTestMailer(t)
+	alice := createTestUser(t, "alice")
+	testDB.Model(&alice).Update("email", "alice@example.com")
+
+	w := serveAs(alice.ID, "POST", "/email/resend", "/email/resend", nil, ResendVerificationHandler)
+	expectStatus(t, w, http.StatusOK)
+	mails := waitForMail(t, m, 1)
+	if len(mails) != 1 || mails[0].To != "alice@example.com" {
+		t.Fatalf("mails = %+v", mails)
+	}
+	token := tokenFromMail(t, mails[0])
+
+	// 送った後にメールアドレスが変わったリンク
+	stale, _ := issuePurposeToken(emailVerifyTokenType, alice.ID, time.Minute, map[string]interface{}{"email": "old@example.com"})
+	reset, _ := issuePurposeToken(passwordResetTokenType, alice.ID, time.Minute, nil)
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"missing token", "", http.StatusBadRequest},
+		{"email changed since", stale, http.StatusBadRequest},
+		{"token for another purpose", reset, http.StatusBadRequest},
+		{"valid link", token, http.StatusOK},
+		{"link used twice", token, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(0, "POST", "/email/verify", "/email/verify", map[string]string{"token": tt.token}, VerifyEmailHandler)
+			expectStatus(t, w, tt.wantStatus)
+		})
+	}
+
+	var user models.User
+	testDB.First(&user, alice.ID)
+	if user.EmailVerifiedAt == nil {
+		t.Error("email_verified_at was not recorded")
+	}
+	w = serveAs(alice.ID, "POST", "/email/resend", "/email/resend", nil, ResendVerificationHandler)
+	expectStatus(t, w, http.StatusConflict)
+}
+
+// 送信に失敗するメーラー
+type failingMailer struct{}
+
+func (failingMailer) Send(mailer.Message) error { return errors.New("smtp: connection refused") }
+
+func TestResendVerificationReportsSendResult(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	alice := createTestUser(t, "alice")
+	testDB.Model(&alice).Update("email", "alice@example.com")
+
+	tests := []struct {
+		name       string
+		mailer     mailer.Mailer
+		wantStatus int
+	}{
+		{"sent", mailer.NewMemory(), http.StatusOK},
+		{"send failed", failingMailer{}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := mailSender
+			mailSender = tt.mailer
+			defer func() { mailSender = prev }()
+
+			w := serveAs(alice.ID, "POST", "/email/resend", "/email/resend", nil, ResendVerificationHandler)
+			expectStatus(t, w, tt.wantStatus)
+			// 成功を返したときにはもう送られている
+			if m, ok := tt.mailer.(*mailer.Memory); ok && len(m.Sent()) != 1 {
+				t.Errorf("sent = %d, want 1", len(m.Sent()))
+			}
+		})
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	useTestHub(t)
+	m := useTestMailer(t)
+	alice := createTestUser(t, "alice")
+	testDB.Model(&alice).Updates(map[string]interface{}{"email": "alice@example.com", "email_verified_at": time.Now()})
+	unverified := createTestUser(t, "bob")
+	testDB.Model(&unverified).Update("email", "bob@example.com")
+	session := loginTestSession(t, alice.ID, "laptop")
+
+	// 登録の有無にかかわらず同じレスポンスを返し、確認済みのアドレスにだけ送る
+	for _, email := range []string{"nobody@example.com", "bob@example.com", " Alice@Example.com "} {
+		w := serveAs(0, "POST", "/password/forgot", "/password/forgot", map[string]string{"email": email}, ForgotPasswordHandler)
+		expectStatus(t, w, http.StatusOK)
+	}
+	mails := waitForMail(t, m, 1)
+	time.Sleep(50 * time.Millisecond) // 余計なメールが送られていないか
+	if mails = m.Sent(); len(mails) != 1 || mails[0].To != "alice@example.com" {
+		t.Fatalf("mails = %+v, want one to alice", mails)
+	}
+	token := tokenFromMail(t, mails[0])
+
+	tests := []struct {
+		name       string
+		token      string
+		password   string
+		wantStatus int
+	}{
+		{"invalid token", "xxx", "N3w-passw0rd!", http.StatusBadRequest},
+		{"weak password", token, "short", http.StatusBadRequest},
+		{"valid", token, "N3w-passw0rd!", http.StatusOK},
+		{"link used twice", token, "An0ther-passw0rd!", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]string{"token": tt.token, "password": tt.password}
+			w := serveAs(0, "POST", "/password/reset", "/password/reset", body, ResetPasswordHandler)
+			expectStatus(t, w, tt.wantStatus)
+		})
+	}
+
+	// 再設定すると全端末からログアウトされる
+	if _, err := ParseAccessToken(session.Token); err == nil {
+		t.Error("session from before the reset is still valid")
+	}
+}
@@ -3,10 +3,12 @@ package handlers
 import (
 	"backend/database"
 	"backend/models"
+	"errors"
 	"log"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -24,32 +26,62 @@ func parseUint(s string) uint {
 	return uint(u64)
 }
 
-// メッセージ送信
+// 送信結果（再送で既存のメッセージが返った場合は duplicate = true）
+type sendMessageResponse struct {
+	models.Message
+	Duplicate bool `json:"duplicate"`
+}
+
+// メッセージ送信（送信者はログイン中のユーザー）
 func SendMessageHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
 	var input struct {
-		RoomID       uint   `json:"room_id"`
-		SenderID     uint   `json:"sender_id"`
-		Content      string `json:"content"`
-		ThreadRootID *uint  `json:"thread_root_id"` // スレッド型チャットを想定する場合
+		RoomID       uint    `json:"room_id"`
+		Content      string  `json:"content"`
+		ThreadRootID *uint   `json:"thread_root_id"` // スレッド型チャットを想定する場合
+		ClientMsgID  *string `json:"client_msg_id"`  // 再送時の重複防止用（任意）
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	if !validClientMsgID(input.ClientMsgID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid client_msg_id"})
+		return
+	}
+	if !isRoomMember(db, input.RoomID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+		return
+	}
+	if input.ThreadRootID != nil {
+		if err := validateThreadRoot(input.RoomID, *input.ThreadRootID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
-	// ① メッセージを保存
-	message, err := database.SendMessage(db, input.RoomID, input.SenderID, input.Content, input.ThreadRootID)
+	// ① メッセージを保存（自分が同じ client_msg_id で送っていれば既存のメッセージが返る）
+	message, duplicate, err := database.SendMessage(db, input.RoomID, userID, input.Content, input.ThreadRootID, input.ClientMsgID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
 		return
 	}
 
-	// ② メンション処理を追加
-	handleMentions(db, message)
+	// ② 新規作成時のみ、WebSocket と同じようにルームへ配信してメンション処理を行う
+	if !duplicate {
+		broadcast <- message
+		handleMentions(db, message)
+	}
+
+	// ③ レスポンス（保存されたメッセージ）
+	c.JSON(http.StatusOK, sendMessageResponse{Message: message, Duplicate: duplicate})
+}
 
-	// ③ レスポンス
-	c.JSON(http.StatusOK, gin.H{"message": "Message sent successfully"})
+// client_msg_id は省略可、指定する場合は 1〜64 文字
+func validClientMsgID(id *string) bool {
+	return id == nil || (len(*id) > 0 && len(*id) <= 64)
 }
 
 // メッセージ一覧取得
@@ -66,72 +98,18 @@ func GetMessagesHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room_id"})
 		return
 	}
-
-	// 🔸 メッセージを取得
-	var messages []models.Message
-	if err := db.
-		Preload("Attachments").
-		Where("room_id = ? AND deleted_at IS NULL", roomID).
-		Order("created_at asc").
-		Find(&messages).Error; err != nil {
-		log.Println("❌ メッセージ取得失敗:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get messages"})
-		return
-	}
-
-	// 添付ファイルも一緒に取得
-	if err := db.Preload("Attachments").Where("room_id = ?", roomID).Find(&messages).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "メッセージ取得失敗"})
-		return
-	}
-
-	// 🔸 自分が既読にした message_id を取得
-	var readIDs []uint
-	if err := db.Table("message_reads").
-		Select("message_id").
-		Where("user_id = ?", userID).
-		Find(&readIDs).Error; err != nil {
-		log.Println("❌ 既読情報取得失敗:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get read info"})
-		return
-	}
-	readMap := make(map[uint]bool)
-	for _, id := range readIDs {
-		readMap[id] = true
-	}
-
-	// 🔸 自分のメッセージで、他人が既読にしたもの
-	var readByOthersIDs []uint
-	if err := db.Table("message_reads").
-		Select("message_id").
-		Joins("JOIN messages ON messages.id = message_reads.message_id").
-		Where("messages.room_id = ? AND messages.sender_id = ? AND message_reads.user_id != ?", roomID, userID, userID).
-		Find(&readByOthersIDs).Error; err != nil {
-		log.Println("❌ 他人の既読情報取得失敗:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get readByOthers info"})
+	if !isRoomMember(db, uint(roomID), userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
 		return
 	}
-	readByOthersMap := make(map[uint]bool)
-	for _, id := range readByOthersIDs {
-		readByOthersMap[id] = true
-	}
-
-	// 🔸 メッセージごとにフラグ付けして返却
-	var result []models.MessageWithRead
-	for _, msg := range messages {
-		result = append(result, models.MessageWithRead{
-			Message:        msg,
-			IsRead:         readMap[msg.ID],
-			IsReadByOthers: readByOthersMap[msg.ID],
-		})
-	}
 
-	c.JSON(http.StatusOK, result)
+	// 🔸 カーソル（before / after / around, limit）に従って1ページ分を既読フラグ付きで返す
+	respondMessagePage(c, roomID, userID)
 }
 
 // メッセージ送信（グループ + スレッド対応）
+// ルームは本文の room_id で指定する
 func SendGroupMessageHandler(c *gin.Context) {
-	roomID := c.Param("roomId")
 	userID := GetCurrentUserID(c)
 
 	var req models.Message
@@ -139,46 +117,63 @@ func SendGroupMessageHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.RoomID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "room_id is required"})
+		return
+	}
 
 	// ユーザーがそのルームのメンバーか確認
-	var member models.RoomMember
-	if err := db.Where("room_id = ? AND user_id = ?", roomID, userID).First(&member).Error; err != nil {
+	if !isRoomMember(db, req.RoomID, userID) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
 		return
 	}
 
 	// thread_root_id が指定されている場合は検証
 	if req.ThreadRootID != nil {
-		var rootMsg models.Message
-		if err := db.First(&rootMsg, *req.ThreadRootID).Error; err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid thread root ID"})
-			return
-		}
-		if rootMsg.RoomID != parseUint(roomID) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "thread message must belong to the same room"})
+		if err := validateThreadRoot(req.RoomID, *req.ThreadRootID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 	}
 
+	if !validClientMsgID(req.ClientMsgID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid client_msg_id"})
+		return
+	}
+
 	msg := models.Message{
-		RoomID:       parseUint(roomID),
+		RoomID:       req.RoomID,
 		SenderID:     userID,
 		Content:      req.Content,
 		ThreadRootID: req.ThreadRootID,
+		ClientMsgID:  req.ClientMsgID,
 	}
 
-	if err := db.Create(&msg).Error; err != nil {
+	// 再送なら msg は既存のメッセージに置き換わる
+	duplicate, err := database.CreateMessage(db, &msg)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to post message"})
 		return
 	}
 
-	c.JSON(http.StatusOK, msg)
+	// 新規作成時のみルームへ配信（スレッドの返信は thread_reply として届く）
+	if !duplicate {
+		broadcast <- msg
+		handleMentions(db, msg)
+	}
+
+	c.JSON(http.StatusOK, sendMessageResponse{Message: msg, Duplicate: duplicate})
 }
 
 // メッセージ一覧取得(グループ)
+// ルームはクエリの room_id で指定する（GET /messages と同じ）
 func GetGroupMessagesHandler(c *gin.Context) {
-	roomIDStr := c.Param("roomId")
+	roomIDStr := c.Query("room_id")
 	userID := GetCurrentUserID(c)
+	if roomIDStr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "room_id is required"})
+		return
+	}
 
 	// ルームIDバリデーション
 	roomID, err := strconv.Atoi(roomIDStr)
@@ -194,32 +189,8 @@ func GetGroupMessagesHandler(c *gin.Context) {
 		return
 	}
 
-	// メッセージ取得
-	var messages []models.Message
-	if err := db.
-		Preload("Attachments").
-		Where("room_id = ?", roomID).
-		Order("created_at ASC").
-		Find(&messages).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get messages"})
-		return
-	}
-
-	// 既読情報を付与
-	var result []models.MessageWithRead
-	for _, m := range messages {
-		var count int64
-		db.Model(&models.MessageRead{}).
-			Where("message_id = ? AND user_id = ?", m.ID, userID).
-			Count(&count)
-
-		result = append(result, models.MessageWithRead{
-			Message: m,
-			IsRead:  count > 0,
-		})
-	}
-
-	c.JSON(http.StatusOK, result)
+	// メッセージ取得（カーソルでページング、既読情報を付与）
+	respondMessagePage(c, roomID, userID)
 }
 
 // POST /messages/{id}/read
@@ -271,13 +242,8 @@ func MarkMessageAsRead(c *gin.Context) {
 		RoomID:    msg.RoomID,
 	}
 
-	// ✅ 全クライアントにブロードキャスト（最低構成）
-	for _, client := range roomClients[roomID] {
-		if err := client.WriteJSON(notification); err != nil {
-			log.Println("❌ WebSocket送信エラー:", err)
-			client.Close()
-		}
-	}
+	// ✅ ルームの全クライアントにブロードキャスト
+	BroadcastToRoom(roomID, notification)
 
 	c.JSON(200, gin.H{"status": "ok"})
 }
@@ -336,15 +302,11 @@ func MarkAllMessagesAsRead(c *gin.Context) {
 			Type:      "read",
 			MessageID: lastMessageID,
 			UserID:    userID,
+			RoomID:    uint(roomID),
 		}
 
 		// そのroomのWebSocketクライアントに送る
-		for _, client := range roomClients[uint(roomID)] {
-			if err := client.WriteJSON(notification); err != nil {
-				log.Println("❌ WebSocket送信エラー:", err)
-				client.Close()
-			}
-		}
+		BroadcastToRoom(uint(roomID), notification)
 	}
 	c.JSON(200, gin.H{"status": "ok", "read_count": len(reads)})
 }
@@ -369,6 +331,7 @@ func GetUnreadCount(c *gin.Context) {
 // 編集
 func UpdateMessageHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		userID := GetCurrentUserID(c)
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
@@ -383,26 +346,64 @@ func UpdateMessageHandler(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
 			return
 		}
-
-		var msg models.Message
-		if err := db.First(&msg, id).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		if strings.TrimSpace(body.Content) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "content is required"})
 			return
 		}
 
-		msg.Content = body.Content
-		msg.UpdatedAt = time.Now()
+		var msg models.Message
+		changed := false
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// 同時に編集されても履歴の「編集前」がずれないよう、行をロックして読む
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&msg, id).Error; err != nil {
+				return err
+			}
+			if err := checkEditable(msg, userID); err != nil {
+				return err
+			}
+			if msg.Content == body.Content {
+				return nil
+			}
 
-		if err := db.Save(&msg).Error; err != nil {
+			// 編集前後の本文を履歴に残してから書き換える
+			now := time.Now()
+			if err := tx.Create(&models.MessageRevision{
+				MessageID:  msg.ID,
+				EditorID:   userID,
+				OldContent: msg.Content,
+				NewContent: body.Content,
+				CreatedAt:  now,
+			}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&msg).Updates(map[string]interface{}{
+				"content":   body.Content,
+				"edited_at": now,
+			}).Error; err != nil {
+				return err
+			}
+			msg.Content = body.Content
+			msg.EditedAt = &now
+			changed = true
+			return nil
+		})
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		case errors.Is(err, errNotMessageSender), errors.Is(err, errEditWindowExpired):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			log.Println("❌ メッセージ編集失敗:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
 			return
 		}
 
-		BroadcastToRoom(msg.RoomID, map[string]interface{}{
-			"type":        "update",
-			"message_id":  msg.ID,
-			"new_content": msg.Content,
-		})
+		// 本文が変わったときだけ通知する
+		if changed {
+			BroadcastToRoom(msg.RoomID, updateEvent(msg))
+		}
 
 		c.JSON(http.StatusOK, msg)
 	}
@@ -432,10 +433,7 @@ func DeleteMessageHandler(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		// WebSocket ブロードキャスト
-		BroadcastToRoom(msg.RoomID, map[string]interface{}{
-			"type":       "delete",
-			"message_id": msg.ID,
-		})
+		BroadcastToRoom(msg.RoomID, deleteEvent(msg))
 
 		c.Status(http.StatusNoContent)
 	}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"backend/models"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidClientMsgID(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name string
+		id   *string
+		want bool
+	}{
+		{"omitted", nil, true},
+		{"uuid", str("0b7e2c1e-8f0a-4a57-9d55-7d3f6c1f4a10"), true},
+		{"64 chars", str(strings.Repeat("a", 64)), true},
+		{"empty", str(""), false},
+		{"65 chars", str(strings.Repeat("a", 65)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validClientMsgID(tt.id); got != tt.want {
+				t.Errorf("validClientMsgID = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// メッセージ送信の2つのエンドポイント（同じ振る舞いを確認する）
+var sendEndpoints = []struct {
+	path    string
+	handler gin.HandlerFunc
+}{
+	{"/messages", SendMessageHandler},
+	{"/messages/group", SendGroupMessageHandler},
+}
+
+func TestSendMessageDeduplicatesClientMsgID(t *testing.T) {
+	for _, ep := range sendEndpoints {
+		t.Run(ep.path, func(t *testing.T) {
+			setupTestDB(t)
+			alice := createTestUser(t, "alice")
+			bob := createTestUser(t, "bob")
+			room := createTestRoom(t, alice.ID, bob.ID)
+
+			send := func(userID uint, clientMsgID, content string) sendMessageResponse {
+				t.Helper()
+				body := map[string]interface{}{"room_id": room, "content": content}
+				if clientMsgID != "" {
+					body["client_msg_id"] = clientMsgID
+				}
+				w := serveAs(userID, "POST", ep.path, ep.path, body, ep.handler)
+				expectStatus(t, w, http.StatusOK)
+				var res sendMessageResponse
+				decodeBody(t, w, &res)
+				return res
+			}
+
+			first := send(alice.ID, "c1", "hello")
+			tests := []struct {
+				name          string
+				userID        uint
+				clientMsgID   string
+				content       string
+				wantDuplicate bool
+				wantSameID    bool
+			}{
+				{"retry returns the stored message", alice.ID, "c1", "hello (retry)", true, true},
+				{"same id from another sender", bob.ID, "c1", "hi", false, false},
+				{"new id", alice.ID, "c2", "again", false, false},
+				{"no id is never a duplicate", alice.ID, "", "no id", false, false},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					res := send(tt.userID, tt.clientMsgID, tt.content)
+					if res.Duplicate != tt.wantDuplicate {
+						t.Errorf("duplicate = %v, want %v", res.Duplicate, tt.wantDuplicate)
+					}
+					if (res.ID == first.ID) != tt.wantSameID {
+						t.Errorf("id = %d, first = %d, want same = %v", res.ID, first.ID, tt.wantSameID)
+					}
+					if tt.wantDuplicate && res.Content != first.Content {
+						t.Errorf("content = %q, want stored %q", res.Content, first.Content)
+					}
+					if res.SenderID != tt.userID {
+						t.Errorf("sender_id = %d, want %d", res.SenderID, tt.userID)
+					}
+				})
+			}
+
+			var count int64
+			testDB.Model(&models.Message{}).Where("room_id = ?", room).Count(&count)
+			if count != 4 {
+				t.Errorf("stored messages = %d, want 4", count)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+// handlers/metrics.go
+package handlers
+
+import "expvar"
+
+// WebSocket 関連のメトリクス（内部向けアドレス DEBUG_ADDR の /debug/vars で参照できる）
+var (
+	wsActiveConnections = expvar.NewInt("ws_active_connections")
+	wsReapedConnections = expvar.NewInt("ws_reaped_connections")
+	wsEvictedClients    = expvar.NewInt("ws_evicted_slow_clients")
+)
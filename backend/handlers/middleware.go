@@ -5,55 +5,109 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("super-secret-key")
+// アクセストークンの中身
+type AccessClaims struct {
+	UserID    uint
+	JTI       string // トークンごとのID
+	SessionID string // ログインごとのID（リフレッシュトークンの系列）
+	ExpiresAt time.Time
+	Scopes    []string // パーソナルアクセストークンのスコープ（nil ならすべて）
+	TokenID   uint     // パーソナルアクセストークンのID（JWT なら 0）
+}
 
-func ParseJWT(tokenString string) (uint, error) {
+// スコープを持っているか（パスワード・SSO でのログインはすべて持つ）
+func (a *AccessClaims) HasScope(scope string) bool {
+	if a.Scopes == nil {
+		return true
+	}
+	for _, s := range a.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// アクセストークンを検証する（署名・有効期限・失効）
+// パーソナルアクセストークン（cat_ で始まる）も受け付ける
+func ParseAccessToken(tokenString string) (*AccessClaims, error) {
 	// Bearer トークン対応
 	if strings.HasPrefix(tokenString, "Bearer ") {
 		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 	}
 
-	log.Println("🔍 Starting token parse:", tokenString[:30])
+	// パーソナルアクセストークンは DB のハッシュと照合（平文はログに出さない）
+	if strings.HasPrefix(tokenString, personalTokenPrefix) {
+		return parsePersonalAccessToken(tokenString)
+	}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return jwtSecret, nil
-	})
+	log.Println("🔍 Starting token parse:", tokenString[:min(len(tokenString), 30)])
+
+	// kid から検証鍵を選ぶ（ローテーション中は複数の鍵が有効）
+	token, err := jwt.Parse(tokenString, jwtKeys.keyFunc)
 	if err != nil || !token.Valid {
-		return 0, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return 0, errors.New("invalid claims")
+		return nil, errors.New("invalid claims")
+	}
+
+	// チャレンジトークンなど、アクセストークン以外の JWT は受け付けない
+	if _, ok := claims["typ"]; ok {
+		return nil, errors.New("invalid token type")
 	}
 
 	userIDFloat, ok := claims["user_id"].(float64)
 	if !ok {
-		return 0, errors.New("user_id not found")
+		return nil, errors.New("user_id not found")
 	}
 
-	return uint(userIDFloat), nil
+	result := &AccessClaims{UserID: uint(userIDFloat)}
+	result.JTI, _ = claims["jti"].(string)
+	result.SessionID, _ = claims["sid"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		result.ExpiresAt = exp.Time
+	}
+
+	// ログアウト済みのトークンは拒否
+	if revocations.isRevoked(result) {
+		return nil, errors.New("token revoked")
+	}
+
+	return result, nil
+}
+
+func ParseJWT(tokenString string) (uint, error) {
+	claims, err := ParseAccessToken(tokenString)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID, nil
 }
 
 // ユーザー認証
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := c.GetHeader("Authorization")
-		userID, err := ParseJWT(tokenString)
+		claims, err := ParseAccessToken(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
-		c.Set("user_id", userID)
+		if claims.TokenID == 0 {
+			touchSession(claims.SessionID)
+		}
+		c.Set("user_id", claims.UserID)
+		c.Set("access_claims", claims)
 		c.Next()
 	}
 }
@@ -67,3 +121,13 @@ func GetCurrentUserID(c *gin.Context) uint {
 	}
 	return 0
 }
+
+// 認証に使ったアクセストークンの中身を取得
+func GetAccessClaims(c *gin.Context) *AccessClaims {
+	if v, exists := c.Get("access_claims"); exists {
+		if claims, ok := v.(*AccessClaims); ok {
+			return claims
+		}
+	}
+	return nil
+}
@@ -0,0 +1,549 @@
+// handlers/oidc.go
+package handlers
+
+import (
+	"backend/models"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// ==============================
+// 🔹 シングルサインオン（OpenID Connect）の設定
+// ==============================
+// - OIDC_ISSUER         : IdP の issuer（例: https://idp.example.com。未設定なら SSO は無効）
+// - OIDC_CLIENT_ID      : IdP に登録したクライアントID
+// - OIDC_CLIENT_SECRET  : クライアントシークレット（公開クライアントなら空。PKCE は常に使う）
+// - OIDC_REDIRECT_URL   : IdP に登録したコールバック URL（既定 http://localhost:8080/auth/oidc/callback）
+// - OIDC_SCOPES         : 要求するスコープ（既定 "openid profile email"）
+// - OIDC_POST_LOGIN_URL : ログイン後に戻るフロントエンドのページ（トークンは URL のフラグメントで渡す）
+var (
+	oidcIssuer       = os.Getenv("OIDC_ISSUER")
+	oidcClientID     = os.Getenv("OIDC_CLIENT_ID")
+	oidcClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	oidcRedirectURL  = envString("OIDC_REDIRECT_URL", "http://localhost:8080/auth/oidc/callback")
+	oidcScopes       = envString("OIDC_SCOPES", "openid profile email")
+	oidcPostLoginURL = envString("OIDC_POST_LOGIN_URL", appURL+"/oidc-callback")
+)
+
+const (
+	oidcStateTokenType = "oidc_state"
+	oidcStateCookie    = "oidc_state"
+	oidcStateTTL       = 10 * time.Minute
+
+	// IdP の公開鍵を取り直す最短間隔（知らない kid のトークンが大量に来ても IdP に負荷をかけない）
+	oidcKeysRefreshInterval = time.Minute
+)
+
+// ID トークンの署名として受け付けるアルゴリズム（none や HS256 は受け付けない）
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "ES512", "EdDSA"}
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// IdP のディスカバリー情報（/.well-known/openid-configuration）
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IdP の情報と公開鍵のキャッシュ
+type oidcProvider struct {
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+var oidcIdP = &oidcProvider{}
+
+// ID トークンから取り出すユーザー情報
+type oidcIdentity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+func oidcEnabled() bool {
+	return oidcIssuer != "" && oidcClientID != ""
+}
+
+func getJSON(rawURL string, out interface{}) error {
+	resp, err := oidcHTTPClient.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// ディスカバリー情報（初回だけ取得）
+func (p *oidcProvider) config() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := getJSON(strings.TrimSuffix(oidcIssuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	// 別の IdP になりすまされていないか（RFC 8414 / OIDC Discovery 4.3）
+	if d.Issuer != oidcIssuer {
+		return nil, fmt.Errorf("issuer mismatch: %q", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("incomplete discovery document")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// kid に対応する公開鍵（知らない kid なら鍵を取り直す。IdP の鍵ローテーション対策）
+func (p *oidcProvider) key(kid string) (crypto.PublicKey, error) {
+	cfg, err := p.config()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.keys[kid]; !ok && time.Since(p.keysFetchedAt) > oidcKeysRefreshInterval {
+		var set struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		if err := getJSON(cfg.JWKSURI, &set); err != nil {
+			return nil, err
+		}
+		keys := make(map[string]crypto.PublicKey)
+		for _, k := range set.Keys {
+			if k.Use != "" && k.Use != "sig" {
+				continue
+			}
+			pub, err := k.publicKey()
+			if err != nil {
+				log.Printf("⚠️ oidc: skipping key %q: %v\n", k.Kid, err)
+				continue
+			}
+			keys[k.Kid] = pub
+		}
+		p.keys = keys
+		p.keysFetchedAt = time.Now()
+	}
+
+	if pub, ok := p.keys[kid]; ok {
+		return pub, nil
+	}
+	// kid なしのトークンは、鍵が1つだけのときに限り受け付ける
+	if kid == "" && len(p.keys) == 1 {
+		for _, pub := range p.keys {
+			return pub, nil
+		}
+	}
+	return nil, errors.New("unknown kid")
+}
+
+// IdP が公開している鍵（JWK）
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, errors.New("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported kty %q", k.Kty)
+	}
+}
+
+// 認可コードを ID トークンと交換し、検証してユーザー情報を返す
+func (p *oidcProvider) exchange(code, verifier, nonce string) (*oidcIdentity, error) {
+	cfg, err := p.config()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oidcRedirectURL)
+	form.Set("client_id", oidcClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, cfg.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if oidcClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(oidcClientID), url.QueryEscape(oidcClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tr struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint: %s %s", resp.Status, tr.Error)
+	}
+
+	return p.verifyIDToken(cfg, tr.IDToken, nonce)
+}
+
+// ID トークンの署名・issuer・audience・有効期限・nonce を検証する
+func (p *oidcProvider) verifyIDToken(cfg *oidcDiscovery, raw, nonce string) (*oidcIdentity, error) {
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(kid)
+	},
+		jwt.WithValidMethods(oidcSigningMethods),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(oidcClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid id_token claims")
+	}
+	if got, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce mismatch")
+	}
+
+	id := &oidcIdentity{}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.PreferredUsername, _ = claims["preferred_username"].(string)
+	// IdP によっては文字列の "true" で返す
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		id.EmailVerified = v
+	case string:
+		id.EmailVerified = v == "true"
+	}
+	if id.Subject == "" {
+		return nil, errors.New("id_token has no sub")
+	}
+	return id, nil
+}
+
+// IdP のユーザーに対応する User を探す（初回ログインなら作る）
+// 既存のローカルユーザーとはメールアドレスが同じでも自動で結びつけない（乗っ取り防止）
+func findOrCreateOIDCUser(issuer string, id *oidcIdentity) (models.User, error) {
+	var user models.User
+	err := db.Where("oidc_issuer = ? AND oidc_subject = ?", issuer, id.Subject).First(&user).Error
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	user = models.User{OIDCIssuer: issuer, OIDCSubject: id.Subject}
+
+	// IdP が確認済みのメールアドレスで、まだ誰も使っていなければ登録する
+	if email := normalizeEmail(id.Email); email != "" && id.EmailVerified {
+		var count int64
+		db.Model(&models.User{}).Where("email = ?", email).Count(&count)
+		if count == 0 {
+			now := time.Now()
+			user.Email = email
+			user.EmailVerifiedAt = &now
+		}
+	}
+
+	for _, name := range oidcUsernameCandidates(id) {
+		var count int64
+		if err := db.Model(&models.User{}).Where("username = ?", name).Count(&count).Error; err != nil {
+			return user, err
+		}
+		if count > 0 {
+			continue
+		}
+		user.Username = name
+		if err := db.Create(&user).Error; err != nil {
+			// 同時に同じ名前で作られた場合は次の候補へ
+			log.Println("⚠️ SSO user create retry:", err)
+			continue
+		}
+		log.Printf("👤 SSO user created: user %d (%s)\n", user.ID, user.Username)
+		return user, nil
+	}
+	return user, errors.New("could not allocate a username")
+}
+
+// IdP の preferred_username やメールアドレスから、ユーザー名の候補を作る
+func oidcUsernameCandidates(id *oidcIdentity) []string {
+	base := id.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(id.Email, "@")
+	}
+
+	// 使えない文字を除き、連番を付けられるよう短めに切る
+	var b strings.Builder
+	for _, r := range base {
+		if usernamePattern.MatchString(string(r)) {
+			b.WriteRune(r)
+		}
+	}
+	base = b.String()
+	if max := usernameMaxLength - 7; len(base) > max && max > 0 {
+		base = base[:max]
+	}
+	if len(base) < usernameMinLength {
+		base = "user" + base
+	}
+
+	candidates := []string{base}
+	for i := 2; i <= 9; i++ {
+		candidates = append(candidates, fmt.Sprintf("%s%d", base, i))
+	}
+	for i := 0; i < 3; i++ {
+		if suffix, err := randomToken(4); err == nil {
+			candidates = append(candidates, base+"_"+strings.Map(func(r rune) rune {
+				if usernamePattern.MatchString(string(r)) {
+					return r
+				}
+				return 'x'
+			}, suffix[:5]))
+		}
+	}
+	return candidates
+}
+
+// フロントエンドに戻す（結果は URL のフラグメントに載せ、サーバーのログに残らないようにする）
+func redirectToFrontend(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, oidcPostLoginURL+"#"+values.Encode())
+}
+
+// ==============================
+// 🔹 SSO ログイン開始ハンドラー
+// ==============================
+// - リクエスト: GET /auth/oidc/login（ブラウザで開く）
+// - 呼び出し元: frontend の LoginPage の「社内アカウントでログイン」リンク
+// - 処理:
+//  1. state・nonce・PKCE の code_verifier を作り、署名付き Cookie に保存
+//  2. IdP の認可エンドポイントへリダイレクト
+func OIDCLoginHandler(c *gin.Context) {
+	if !oidcEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "シングルサインオンは設定されていません"})
+		return
+	}
+
+	cfg, err := oidcIdP.config()
+	if err != nil {
+		log.Println("❌ oidc discovery error:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "認証サーバーに接続できません"})
+		return
+	}
+
+	state, err1 := randomToken(16)
+	nonce, err2 := randomToken(16)
+	verifier, err3 := randomToken(32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ログインの開始に失敗しました"})
+		return
+	}
+	stateToken, err := issuePurposeToken(oidcStateTokenType, 0, oidcStateTTL, jwt.MapClaims{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ログインの開始に失敗しました"})
+		return
+	}
+
+	// IdP からのリダイレクト（トップレベルの GET）でも送られるよう SameSite=Lax
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, stateToken, int(oidcStateTTL.Seconds()), "/auth/oidc",
+		"", strings.HasPrefix(oidcRedirectURL, "https://"), true)
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", oidcClientID)
+	q.Set("redirect_uri", oidcRedirectURL)
+	q.Set("scope", oidcScopes)
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(cfg.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	c.Redirect(http.StatusFound, cfg.AuthorizationEndpoint+sep+q.Encode())
+}
+
+// ==============================
+// 🔹 SSO コールバックハンドラー
+// ==============================
+// - リクエスト: GET /auth/oidc/callback?code=...&state=...（IdP からのリダイレクト）
+// - 処理:
+//  1. Cookie の state と一致するか確認（CSRF 対策）
+//  2. 認可コードと code_verifier でトークンを取得し、ID トークンを検証
+//  3. IdP の subject に対応するユーザーを探す（初回はユーザーを作成）
+//  4. /login と同じトークンを発行し、フロントエンドへリダイレクト
+func OIDCCallbackHandler(c *gin.Context) {
+	if !oidcEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "シングルサインオンは設定されていません"})
+		return
+	}
+
+	stateToken, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", strings.HasPrefix(oidcRedirectURL, "https://"), true)
+
+	if e := c.Query("error"); e != "" {
+		log.Printf("⚠️ oidc: IdP returned error %q: %s\n", e, c.Query("error_description"))
+		redirectToFrontend(c, url.Values{"error": {"シングルサインオンがキャンセルされたか、失敗しました"}})
+		return
+	}
+
+	stateClaims, extra, err := parsePurposeToken(oidcStateTokenType, stateToken)
+	state, _ := extra["state"].(string)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		redirectToFrontend(c, url.Values{"error": {"ログインの有効期限が切れました。もう一度お試しください"}})
+		return
+	}
+	// 同じ state で2回コールバックできないようにする
+	if err := revocations.revokeToken(stateClaims); err != nil {
+		log.Println("❌ oidc state revoke error:", err)
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		redirectToFrontend(c, url.Values{"error": {"認可コードがありません"}})
+		return
+	}
+	verifier, _ := extra["verifier"].(string)
+	nonce, _ := extra["nonce"].(string)
+
+	identity, err := oidcIdP.exchange(code, verifier, nonce)
+	if err != nil {
+		log.Println("❌ oidc exchange error:", err)
+		redirectToFrontend(c, url.Values{"error": {"シングルサインオンに失敗しました"}})
+		return
+	}
+
+	user, err := findOrCreateOIDCUser(oidcIssuer, identity)
+	if err != nil {
+		log.Println("❌ oidc user error:", err)
+		redirectToFrontend(c, url.Values{"error": {"ユーザーの作成に失敗しました"}})
+		return
+	}
+
+	// 二要素認証を有効にしているユーザーは、通常のログインと同じく2段階目へ
+	if user.TOTPEnabled {
+		challenge, err := issueChallengeToken(user.ID, "")
+		if err != nil {
+			redirectToFrontend(c, url.Values{"error": {"トークン生成に失敗しました"}})
+			return
+		}
+		redirectToFrontend(c, url.Values{"challenge_token": {challenge}})
+		return
+	}
+
+	pair, err := startSession(c, user.ID, "")
+	if err != nil {
+		redirectToFrontend(c, url.Values{"error": {"トークン生成に失敗しました"}})
+		return
+	}
+	redirectToFrontend(c, url.Values{
+		"token":         {pair.Token},
+		"refresh_token": {pair.RefreshToken},
+		"expires_in":    {fmt.Sprint(pair.ExpiresIn)},
+	})
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"backend/models"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJSONWebKeyPublicKey(t *testing.T) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name    string
+		jwk     jsonWebKey
+		want    interface{ Equal(crypto.PublicKey) bool }
+		wantErr bool
+	}{
+		{"RSA", jsonWebKey{Kty: "RSA", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())}, &rsaKey.PublicKey, false},
+		{"EC P-256", jsonWebKey{Kty: "EC", Crv: "P-256", X: b64(ecKey.X.Bytes()), Y: b64(ecKey.Y.Bytes())}, &ecKey.PublicKey, false},
+		{"Ed25519", jsonWebKey{Kty: "OKP", Crv: "Ed25519", X: b64(edPub)}, edPub, false},
+		{"RSA without modulus", jsonWebKey{Kty: "RSA", E: "AQAB"}, nil, true},
+		{"unsupported curve", jsonWebKey{Kty: "EC", Crv: "P-192", X: "AQ", Y: "AQ"}, nil, true},
+		{"short Ed25519 key", jsonWebKey{Kty: "OKP", Crv: "Ed25519", X: b64(edPub[:16])}, nil, true},
+		{"X25519 is not for signing", jsonWebKey{Kty: "OKP", Crv: "X25519", X: b64(edPub)}, nil, true},
+		{"symmetric key", jsonWebKey{Kty: "oct"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.jwk.publicKey()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !tt.want.Equal(got) {
+				t.Errorf("key = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCUsernameCandidates(t *testing.T) {
+	tests := []struct {
+		name      string
+		id        oidcIdentity
+		wantFirst string
+	}{
+		{"preferred username", oidcIdentity{PreferredUsername: "alice", Email: "a@example.com"}, "alice"},
+		{"local part of email", oidcIdentity{Email: "bob.smith@example.com"}, "bob.smith"},
+		{"unusable characters removed", oidcIdentity{PreferredUsername: "Carol Jones!"}, "CarolJones"},
+		{"too short", oidcIdentity{PreferredUsername: "d"}, "userd"},
+		{"nothing usable", oidcIdentity{PreferredUsername: "山田"}, "user"},
+		{"too long", oidcIdentity{PreferredUsername: strings.Repeat("e", 40)}, strings.Repeat("e", usernameMaxLength-7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := oidcUsernameCandidates(&tt.id)
+			if got[0] != tt.wantFirst || got[1] != tt.wantFirst+"2" {
+				t.Errorf("candidates = %v, want %q, %q2, ...", got, tt.wantFirst, tt.wantFirst)
+			}
+			// 連番・ランダムな候補もユーザー名のルールを満たす
+			for _, name := range got {
+				if err := validateUsername(name); err != nil {
+					t.Errorf("candidate %q: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+// テスト用の IdP（ディスカバリー・JWKS・トークンエンドポイント）
+type testIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims // トークンエンドポイントが返す ID トークンの中身
+}
+
+// テスト用の IdP を立てて、SSO の設定をそこに向ける
+func useTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{
+			{Kty: "RSA", Kid: "idp-1", Use: "sig", N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes())},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" || r.FormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, "idp-1", idp.claims)})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	prevIssuer, prevClient, prevIdP := oidcIssuer, oidcClientID, oidcIdP
+	oidcIssuer, oidcClientID, oidcIdP = idp.server.URL, "chat-app", &oidcProvider{}
+	t.Cleanup(func() { oidcIssuer, oidcClientID, oidcIdP = prevIssuer, prevClient, prevIdP })
+	return idp
+}
+
+func (idp *testIdP) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// IdP が発行する正しい ID トークンの中身（changes で一部を書き換える）
+func (idp *testIdP) idClaims(nonce string, changes jwt.MapClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   "chat-app",
+		"sub":   "subject-1",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": nonce,
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := useTestIdP(t)
+	cfg, err := oidcIdP.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, idp.idClaims("n", nil)).SignedString([]byte("secret"))
+
+	tests := []struct {
+		name      string
+		token     string
+		wantErr   bool
+		wantEmail bool // email_verified
+	}{
+		{"valid", idp.sign(t, "idp-1", idp.idClaims("n", jwt.MapClaims{"email_verified": true})), false, true},
+		{"email_verified as string", idp.sign(t, "idp-1", idp.idClaims("n", jwt.MapClaims{"email_verified": "true"})), false, true},
+		{"email not verified", idp.sign(t, "idp-1", idp.idClaims("n", nil)), false, false},
+		{"nonce mismatch", idp.sign(t, "idp-1", idp.idClaims("other", nil)), true, false},
+		{"other audience", idp.sign(t, "idp-1", idp.idClaims("n", jwt.MapClaims{"aud": "other-app"})), true, false},
+		{"other issuer", idp.sign(t, "idp-1", idp.idClaims("n", jwt.MapClaims{"iss": "https://evil.example.com"})), true, false},
+		{"expired", idp.sign(t, "idp-1", idp.idClaims("n", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})), true, false},
+		{"no expiry", idp.sign(t, "idp-1", idp.idClaims("n", jwt.MapClaims{"exp": nil})), true, false},
+		{"no subject", idp.sign(t, "idp-1", idp.idClaims("n", jwt.MapClaims{"sub": nil})), true, false},
+		{"unknown kid", idp.sign(t, "idp-2", idp.idClaims("n", nil)), true, false},
+		{"HS256 is not accepted", hs256, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := oidcIdP.verifyIDToken(cfg, tt.token, "n")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (id.Subject != "subject-1" || id.EmailVerified != tt.wantEmail) {
+				t.Errorf("identity = %+v", id)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginAndCallback(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	idp := useTestIdP(t)
+	local := createTestUser(t, "alice")
+	testDB.Model(&local).Update("email", "alice@example.com")
+
+	// ログイン開始：IdP へのリダイレクトと state の Cookie
+	start := func(t *testing.T) (cookie *http.Cookie, state, nonce string) {
+		t.Helper()
+		w := serveAs(0, "GET", "/auth/oidc/login", "/auth/oidc/login", nil, OIDCLoginHandler)
+		expectStatus(t, w, http.StatusFound)
+		loc, _ := url.Parse(w.Header().Get("Location"))
+		q := loc.Query()
+		if !strings.HasPrefix(loc.String(), idp.server.URL+"/authorize?") || q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "chat-app" {
+			t.Fatalf("redirect = %s", loc)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || !cookies[0].HttpOnly {
+			t.Fatalf("cookies = %+v", cookies)
+		}
+		// Cookie の verifier と認可リクエストの code_challenge が対応している
+		_, extra, err := parsePurposeToken(oidcStateTokenType, cookies[0].Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(extra["verifier"].(string)))
+		if q.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			t.Error("code_challenge does not match the verifier")
+		}
+		return cookies[0], q.Get("state"), q.Get("nonce")
+	}
+
+	// コールバック：フロントエンドへのリダイレクトのフラグメントを返す
+	callback := func(t *testing.T, cookie *http.Cookie, query string) url.Values {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/auth/oidc/callback?"+query, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router := gin.New()
+		router.GET("/auth/oidc/callback", OIDCCallbackHandler)
+		router.ServeHTTP(w, r)
+		expectStatus(t, w, http.StatusFound)
+		loc, _ := url.Parse(w.Header().Get("Location"))
+		fragment, _ := url.ParseQuery(loc.Fragment)
+		return fragment
+	}
+
+	tests := []struct {
+		name      string
+		claims    jwt.MapClaims
+		query     func(state string) string
+		noCookie  bool
+		wantError bool
+		wantUser  string // ログインしたユーザー名
+	}{
+		{"first login creates a user", jwt.MapClaims{"preferred_username": "alice", "email": "alice@example.com", "email_verified": true},
+			func(s string) string { return "code=good-code&state=" + s }, false, false, "alice2"},
+		{"second login finds the same user", jwt.MapClaims{"preferred_username": "renamed"},
+			func(s string) string { return "code=good-code&state=" + s }, false, false, "alice2"},
+		{"state mismatch", nil, func(s string) string { return "code=good-code&state=other" }, false, true, ""},
+		{"no state cookie", nil, func(s string) string { return "code=good-code&state=" + s }, true, true, ""},
+		{"IdP returned an error", nil, func(s string) string { return "error=access_denied&state=" + s }, false, true, ""},
+		{"code rejected by IdP", nil, func(s string) string { return "code=bad-code&state=" + s }, false, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookie, state, nonce := start(t)
+			idp.claims = idp.idClaims(nonce, tt.claims)
+			if tt.noCookie {
+				cookie = nil
+			}
+
+			got := callback(t, cookie, tt.query(state))
+			if tt.wantError {
+				if got.Get("error") == "" || got.Get("token") != "" {
+					t.Errorf("fragment = %v, want an error", got)
+				}
+				return
+			}
+			claims, err := ParseAccessToken(got.Get("token"))
+			if err != nil || got.Get("refresh_token") == "" {
+				t.Fatalf("fragment = %v (%v), want tokens", got, err)
+			}
+			var user models.User
+			testDB.First(&user, claims.UserID)
+			if user.Username != tt.wantUser || user.PasswordHash != "" {
+				t.Errorf("logged in as %q (password hash %q), want %q", user.Username, user.PasswordHash, tt.wantUser)
+			}
+			// 既存のローカルユーザーと同じメールアドレスは結びつけない
+			if user.ID == local.ID || user.Email != "" {
+				t.Errorf("SSO user %d took email %q", user.ID, user.Email)
+			}
+
+			// 同じ state で2回はコールバックできない
+			if again := callback(t, cookie, tt.query(state)); again.Get("error") == "" {
+				t.Error("callback with a used state succeeded")
+			}
+		})
+	}
+}
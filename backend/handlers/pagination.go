@@ -0,0 +1,293 @@
+// handlers/pagination.go
+package handlers
+
+import (
+	"backend/models"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 1ページの件数（limit 未指定時の既定値と上限）
+var (
+	messagePageDefault = envInt("MESSAGE_PAGE_DEFAULT", 50)
+	messagePageMax     = envInt("MESSAGE_PAGE_MAX", 200)
+)
+
+// メッセージ一覧のレスポンス
+//   - messages は古い順（created_at, id の昇順）
+//   - next_before / next_after は、その方向にまだメッセージがあるときだけ入る（次の before / after に渡す）
+type messagePage struct {
+	Messages   []models.MessageWithRead `json:"messages"`
+	HasMore    bool                     `json:"has_more"` // 指定した方向（around は前後どちらか）にまだメッセージがある
+	NextBefore *uint                    `json:"next_before"`
+	NextAfter  *uint                    `json:"next_after"`
+}
+
+// ページングの指定（before / after / around はどれか1つ）
+type messageCursor struct {
+	before uint
+	after  uint
+	around uint
+	limit  int
+}
+
+// クエリ（?before=123&limit=50 など）を読む
+func parseMessageCursor(c *gin.Context) (messageCursor, error) {
+	var cur messageCursor
+	set := 0
+	for _, p := range []struct {
+		name string
+		dst  *uint
+	}{
+		{"before", &cur.before},
+		{"after", &cur.after},
+		{"around", &cur.around},
+	} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || id == 0 {
+			return cur, errors.New("invalid " + p.name)
+		}
+		*p.dst = uint(id)
+		set++
+	}
+	if set > 1 {
+		return cur, errors.New("before, after and around cannot be combined")
+	}
+
+	cur.limit = messagePageDefault
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cur, errors.New("invalid limit")
+		}
+		cur.limit = n
+	}
+	if cur.limit > messagePageMax {
+		cur.limit = messagePageMax
+	}
+	return cur, nil
+}
+
+// ページングの対象（ルームのタイムライン・スレッドの返信）
+type messageScope func(tx *gorm.DB) *gorm.DB
+
+// ルームのタイムライン（スレッドの返信は除く。返信は GET /messages/:id/thread で取る）
+func roomTimeline(roomID int) messageScope {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("room_id = ? AND thread_root_id IS NULL", roomID)
+	}
+}
+
+// スレッドの返信
+func threadReplies(rootID uint) messageScope {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("thread_root_id = ?", rootID)
+	}
+}
+
+// カーソルのメッセージ（並び順の基準になる created_at, id）
+// 削除済みのメッセージでも位置の基準には使えるようにする
+func findCursorMessage(scope messageScope, id uint) (*models.Message, error) {
+	var m models.Message
+	if err := db.Unscoped().
+		Scopes(scope).
+		Select("id", "room_id", "created_at").
+		Where("id = ?", id).
+		First(&m).Error; err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// (created_at, id) で cursor より古い（older=true）／新しいメッセージを最大 limit+1 件取得する
+// 1件多く取って、まだ続きがあるかを判定する
+// inclusive=true なら cursor 自身も含める（around 用）。limit=0 なら続きがあるかだけを調べる
+func fetchMessagesFrom(scope messageScope, cursor *models.Message, older, inclusive bool, limit int) ([]models.Message, bool, error) {
+	q := db.Preload("Attachments").Scopes(scope)
+
+	op := ">"
+	order := "created_at ASC, id ASC"
+	if older {
+		op = "<"
+		order = "created_at DESC, id DESC"
+	}
+	if inclusive {
+		op += "="
+	}
+	if cursor != nil {
+		q = q.Where("(created_at, id) "+op+" (?, ?)", cursor.CreatedAt, cursor.ID)
+	}
+
+	var messages []models.Message
+	if err := q.Order(order).Limit(limit + 1).Find(&messages).Error; err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	// 古い方向は新しい順で取っているので、昇順に戻す
+	if older {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, hasMore, nil
+}
+
+// カーソルに従ってメッセージを1ページ分取得する
+func loadMessagePage(scope messageScope, cur messageCursor) (*messagePage, error) {
+	page := &messagePage{}
+	var hasOlder, hasNewer bool
+
+	switch {
+	case cur.after != 0:
+		cursor, err := findCursorMessage(scope, cur.after)
+		if err != nil {
+			return nil, err
+		}
+		messages, more, err := fetchMessagesFrom(scope, cursor, false, false, cur.limit)
+		if err != nil {
+			return nil, err
+		}
+		page.Messages = toMessagesWithRead(messages)
+		hasNewer, page.HasMore = more, more
+		hasOlder = true // カーソルより前がある
+
+	case cur.around != 0:
+		cursor, err := findCursorMessage(scope, cur.around)
+		if err != nil {
+			return nil, err
+		}
+		// 前半は cursor より古いもの、後半は cursor 自身とそれより新しいもの
+		olderLimit := cur.limit / 2
+		older, moreOlder, err := fetchMessagesFrom(scope, cursor, true, false, olderLimit)
+		if err != nil {
+			return nil, err
+		}
+		newer, moreNewer, err := fetchMessagesFrom(scope, cursor, false, true, cur.limit-olderLimit)
+		if err != nil {
+			return nil, err
+		}
+		page.Messages = toMessagesWithRead(append(older, newer...))
+		hasOlder, hasNewer = moreOlder, moreNewer
+		page.HasMore = hasOlder || hasNewer
+
+	default:
+		// before 未指定なら最新から
+		var cursor *models.Message
+		if cur.before != 0 {
+			var err error
+			if cursor, err = findCursorMessage(scope, cur.before); err != nil {
+				return nil, err
+			}
+			hasNewer = true // カーソルより後がある
+		}
+		messages, more, err := fetchMessagesFrom(scope, cursor, true, false, cur.limit)
+		if err != nil {
+			return nil, err
+		}
+		page.Messages = toMessagesWithRead(messages)
+		hasOlder, page.HasMore = more, more
+	}
+
+	if n := len(page.Messages); n > 0 {
+		if hasOlder {
+			page.NextBefore = &page.Messages[0].ID
+		}
+		if hasNewer {
+			page.NextAfter = &page.Messages[n-1].ID
+		}
+	}
+	return page, nil
+}
+
+// 既読フラグは fillReadFlags で付ける
+func toMessagesWithRead(messages []models.Message) []models.MessageWithRead {
+	result := make([]models.MessageWithRead, 0, len(messages))
+	for _, m := range messages {
+		result = append(result, models.MessageWithRead{Message: m})
+	}
+	return result
+}
+
+// ページ内のメッセージに既読フラグを付ける
+//   - isRead: 自分が読んだか
+//   - isReadByOthers: 自分のメッセージを他人が読んだか
+func fillReadFlags(messages []models.MessageWithRead, userID uint) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+
+	var readIDs []uint
+	if err := db.Model(&models.MessageRead{}).
+		Where("message_id IN ? AND user_id = ?", ids, userID).
+		Pluck("message_id", &readIDs).Error; err != nil {
+		return err
+	}
+	var readByOthersIDs []uint
+	if err := db.Model(&models.MessageRead{}).
+		Joins("JOIN messages ON messages.id = message_reads.message_id").
+		Where("message_reads.message_id IN ? AND messages.sender_id = ? AND message_reads.user_id <> ?", ids, userID, userID).
+		Pluck("message_reads.message_id", &readByOthersIDs).Error; err != nil {
+		return err
+	}
+
+	read := make(map[uint]bool, len(readIDs))
+	for _, id := range readIDs {
+		read[id] = true
+	}
+	readByOthers := make(map[uint]bool, len(readByOthersIDs))
+	for _, id := range readByOthersIDs {
+		readByOthers[id] = true
+	}
+	for i := range messages {
+		messages[i].IsRead = read[messages[i].ID]
+		messages[i].IsReadByOthers = readByOthers[messages[i].ID]
+	}
+	return nil
+}
+
+// カーソルを読んでルームのタイムラインを1ページ分返す（GetMessagesHandler / GetGroupMessagesHandler 共通）
+func respondMessagePage(c *gin.Context, roomID int, userID uint) {
+	cur, err := parseMessageCursor(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := loadMessagePage(roomTimeline(roomID), cur)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "cursor message not found"})
+		return
+	}
+	if err == nil {
+		err = fillReadFlags(page.Messages, userID)
+	}
+	if err == nil {
+		err = fillThreadSummaries(page.Messages)
+	}
+	if err == nil {
+		err = fillReactions(page.Messages, userID)
+	}
+	if err != nil {
+		log.Println("❌ メッセージ取得失敗:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get messages"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
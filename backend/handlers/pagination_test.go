@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"backend/models"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseMessageCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    messageCursor
+		wantErr bool
+	}{
+		{"defaults", "", messageCursor{limit: messagePageDefault}, false},
+		{"before", "before=10&limit=20", messageCursor{before: 10, limit: 20}, false},
+		{"after", "after=10", messageCursor{after: 10, limit: messagePageDefault}, false},
+		{"around", "around=10&limit=5", messageCursor{around: 10, limit: 5}, false},
+		{"limit is capped", "limit=100000", messageCursor{limit: messagePageMax}, false},
+		{"before and after", "before=10&after=5", messageCursor{}, true},
+		{"zero id", "before=0", messageCursor{}, true},
+		{"not a number", "after=abc", messageCursor{}, true},
+		{"zero limit", "limit=0", messageCursor{}, true},
+		{"negative limit", "limit=-1", messageCursor{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/messages?"+tt.query, nil)
+			got, err := parseMessageCursor(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetMessagesPagination(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	room := createTestRoom(t, alice.ID)
+
+	// m[1] と m[2] は同じ時刻（id で順序が決まる）
+	base := time.Now().Add(-time.Hour)
+	offsets := []int{0, 1, 1, 2, 3}
+	m := make([]uint, len(offsets))
+	for i, sec := range offsets {
+		msg := models.Message{RoomID: room, SenderID: alice.ID, Content: fmt.Sprint(i), CreatedAt: base.Add(time.Duration(sec) * time.Second)}
+		testDB.Create(&msg)
+		m[i] = msg.ID
+	}
+	// スレッドの返信はタイムラインに含めない
+	testDB.Create(&models.Message{RoomID: room, SenderID: alice.ID, Content: "reply", ThreadRootID: &m[0], CreatedAt: base.Add(10 * time.Second)})
+
+	id := func(i int) *uint { return &m[i] }
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []uint
+		wantMore   bool
+		wantBefore *uint
+		wantAfter  *uint
+	}{
+		{"latest", "limit=2", http.StatusOK, []uint{m[3], m[4]}, true, id(3), nil},
+		{"everything", "", http.StatusOK, m, false, nil, nil},
+		{"before", fmt.Sprintf("before=%d&limit=2", m[3]), http.StatusOK, []uint{m[1], m[2]}, true, id(1), id(2)},
+		{"before the start", fmt.Sprintf("before=%d&limit=2", m[1]), http.StatusOK, []uint{m[0]}, false, nil, id(0)},
+		{"after (same timestamp)", fmt.Sprintf("after=%d&limit=2", m[1]), http.StatusOK, []uint{m[2], m[3]}, true, id(2), id(3)},
+		{"after the end", fmt.Sprintf("after=%d", m[4]), http.StatusOK, []uint{}, false, nil, nil},
+		{"around", fmt.Sprintf("around=%d&limit=3", m[2]), http.StatusOK, []uint{m[1], m[2], m[3]}, true, id(1), id(3)},
+		{"unknown cursor", "before=99999", http.StatusNotFound, nil, false, nil, nil},
+		{"invalid cursor", "before=x", http.StatusBadRequest, nil, false, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(alice.ID, "GET", "/messages", fmt.Sprintf("/messages?room_id=%d&%s", room, tt.query), nil, GetMessagesHandler)
+			expectStatus(t, w, tt.wantStatus)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var page messagePage
+			decodeBody(t, w, &page)
+			ids := []uint{}
+			for _, msg := range page.Messages {
+				ids = append(ids, msg.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) || page.HasMore != tt.wantMore {
+				t.Errorf("ids = %v has_more = %v, want %v %v", ids, page.HasMore, tt.wantIDs, tt.wantMore)
+			}
+			if !reflect.DeepEqual(page.NextBefore, tt.wantBefore) || !reflect.DeepEqual(page.NextAfter, tt.wantAfter) {
+				t.Errorf("next_before = %v next_after = %v, want %v %v", page.NextBefore, page.NextAfter, tt.wantBefore, tt.wantAfter)
+			}
+		})
+	}
+
+	t.Run("not a member", func(t *testing.T) {
+		w := serveAs(bob.ID, "GET", "/messages", fmt.Sprintf("/messages?room_id=%d", room), nil, GetMessagesHandler)
+		expectStatus(t, w, http.StatusForbidden)
+	})
+}
+
+func TestGetGroupMessagesHandler(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	room := createTestRoom(t, alice.ID)
+	m := []uint{
+		createTestMessage(t, room, alice.ID, "0").ID,
+		createTestMessage(t, room, alice.ID, "1").ID,
+		createTestMessage(t, room, alice.ID, "2").ID,
+	}
+
+	// main.go と同じルート（パスにルームIDは含まない）
+	tests := []struct {
+		name       string
+		userID     uint
+		path       string
+		wantStatus int
+		wantIDs    []uint
+	}{
+		{"room_id is required", alice.ID, "/messages/group", http.StatusBadRequest, nil},
+		{"invalid room_id", alice.ID, "/messages/group?room_id=x", http.StatusBadRequest, nil},
+		{"not a member", bob.ID, fmt.Sprintf("/messages/group?room_id=%d", room), http.StatusForbidden, nil},
+		{"latest", alice.ID, fmt.Sprintf("/messages/group?room_id=%d", room), http.StatusOK, m},
+		{"before", alice.ID, fmt.Sprintf("/messages/group?room_id=%d&before=%d&limit=1", room, m[2]), http.StatusOK, m[1:2]},
+		{"after", alice.ID, fmt.Sprintf("/messages/group?room_id=%d&after=%d", room, m[0]), http.StatusOK, m[1:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(tt.userID, "GET", "/messages/group", tt.path, nil, GetGroupMessagesHandler)
+			expectStatus(t, w, tt.wantStatus)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var page messagePage
+			decodeBody(t, w, &page)
+			ids := []uint{}
+			for _, msg := range page.Messages {
+				ids = append(ids, msg.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
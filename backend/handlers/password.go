@@ -0,0 +1,161 @@
+// handlers/password.go
+package handlers
+
+import (
+	"backend/models"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ==============================
+// 🔹 パスワード再設定の申請ハンドラー
+// ==============================
+// - リクエスト: POST /password/forgot { "email": "..." }
+// - 呼び出し元: frontend の pages/reset-password.tsx
+// - 処理:
+//  1. 確認済みのメールアドレスを持つユーザーを探す
+//  2. 見つかれば再設定リンクをメールで送る
+//  3. 登録の有無が分からないよう、結果にかかわらず同じレスポンスを返す
+func ForgotPasswordHandler(c *gin.Context) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	var user models.User
+	// シングルサインオンのユーザー（パスワードなし）は対象外
+	err := db.Where("email = ? AND email_verified_at IS NOT NULL AND password_hash <> ''", normalizeEmail(input.Email)).First(&user).Error
+	if err == nil {
+		if err := sendPasswordResetEmail(user); err != nil {
+			log.Println("❌ password reset mail error:", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "登録されているメールアドレスであれば、再設定用のリンクを送信しました"})
+}
+
+// ==============================
+// 🔹 パスワード再設定ハンドラー
+// ==============================
+// - リクエスト: POST /password/reset { "token": "...", "password": "..." }
+// - 呼び出し元: 再設定メールのリンク先（pages/reset-password.tsx）
+// - 処理:
+//  1. トークンの署名・有効期限・用途を検証
+//  2. トークン発行後にパスワードが変わっていれば拒否（リンクは1回だけ使える）
+//  3. 新しいパスワードがルールを満たしているか確認
+//  4. 新しいパスワードを保存し、全端末のセッションを失効させる
+func ResetPasswordHandler(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.Token == "" || input.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	claims, extra, err := parsePurposeToken(passwordResetTokenType, input.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "再設定リンクが無効か、有効期限が切れています"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, claims.UserID).Error; err != nil || extra["pwh"] != passwordFingerprint(user.PasswordHash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "再設定リンクが無効か、有効期限が切れています"})
+		return
+	}
+
+	if err := validatePassword(input.Password, user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードのハッシュ化に失敗しました"})
+		return
+	}
+	if err := db.Model(&user).Update("password_hash", string(hashedPassword)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードの更新に失敗しました"})
+		return
+	}
+
+	// 乗っ取られていた場合に備えて、全端末からログアウトさせる
+	if err := revocations.revokeToken(claims); err != nil {
+		log.Println("❌ reset token revoke error:", err)
+	}
+	if err := revocations.revokeAllSessions(user.ID); err != nil {
+		log.Println("❌ revoke sessions after reset error:", err)
+	}
+
+	log.Printf("🔑 Password reset: user %d\n", user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "パスワードを再設定しました。新しいパスワードでログインしてください"})
+}
+
+// ==============================
+// 🔹 パスワード変更ハンドラー
+// ==============================
+// - リクエスト: PUT /me/password { "current_password": "...", "new_password": "..." }
+// - 処理:
+//  1. 現在のパスワードを確認
+//  2. 新しいパスワードがルールを満たしているか確認（サインアップと同じルール）
+//  3. 新しいパスワードを保存し、この端末以外のセッションを失効させる
+func ChangePasswordHandler(c *gin.Context) {
+	claims := GetAccessClaims(c)
+	if claims == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "ユーザーIDが取得できません"})
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.CurrentPassword == "" || input.NewPassword == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, claims.UserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.CurrentPassword)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "現在のパスワードが正しくありません"})
+		return
+	}
+	if input.NewPassword == input.CurrentPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "新しいパスワードが現在のパスワードと同じです"})
+		return
+	}
+	if err := validatePassword(input.NewPassword, user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードのハッシュ化に失敗しました"})
+		return
+	}
+	if err := db.Model(&user).Update("password_hash", string(hashedPassword)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードの更新に失敗しました"})
+		return
+	}
+
+	// 他の端末は再ログインが必要（この端末はそのまま使える）
+	if err := revocations.revokeOtherSessions(user.ID, claims.SessionID); err != nil {
+		log.Println("❌ revoke sessions after password change error:", err)
+	}
+
+	log.Printf("🔑 Password changed: user %d\n", user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "パスワードを変更しました"})
+}
@@ -0,0 +1,291 @@
+// handlers/personaltokens.go
+package handlers
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// パーソナルアクセストークンの接頭辞（JWT と見分けるため）
+const personalTokenPrefix = "cat_"
+
+// last_used_at を書き込む最短間隔
+const personalTokenTouchInterval = time.Minute
+
+// ==============================
+// 🔹 スコープ（トークンで呼び出せる API の範囲）
+// ==============================
+// パスワード・SSO でログインしたときのアクセストークンはすべてのスコープを持つ
+const (
+	ScopeAccount       = "account" // 自分のアカウント管理（ログアウト・パスワード・トークン発行など）。パーソナルアクセストークンには付与できない
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeRoomsRead     = "rooms:read"
+	ScopeRoomsWrite    = "rooms:write"
+	ScopeUsersRead     = "users:read"
+)
+
+// パーソナルアクセストークンに付与できるスコープ
+var grantableScopes = map[string]bool{
+	ScopeMessagesRead:  true,
+	ScopeMessagesWrite: true,
+	ScopeRoomsRead:     true,
+	ScopeRoomsWrite:    true,
+	ScopeUsersRead:     true,
+}
+
+// パーソナルアクセストークンのセッションID（接続中のソケットをトークン単位で切断するため）
+func personalTokenSessionID(tokenID uint) string {
+	return fmt.Sprintf("pat:%d", tokenID)
+}
+
+// パーソナルアクセストークンを検証する（失効・期限切れは拒否）
+func parsePersonalAccessToken(tokenString string) (*AccessClaims, error) {
+	var pat models.PersonalAccessToken
+	if err := db.Where("token_hash = ? AND revoked_at IS NULL", hashToken(tokenString)).
+		First(&pat).Error; err != nil {
+		return nil, errors.New("invalid token")
+	}
+	now := time.Now()
+	if pat.ExpiresAt != nil && now.After(*pat.ExpiresAt) {
+		return nil, errors.New("token expired")
+	}
+
+	// リクエストごとに UPDATE しないよう、前回から一定時間たったときだけ記録する
+	if pat.LastUsedAt == nil || now.Sub(*pat.LastUsedAt) > personalTokenTouchInterval {
+		if err := db.Model(&pat).UpdateColumn("last_used_at", now).Error; err != nil {
+			log.Println("❌ token touch error:", err)
+		}
+	}
+
+	claims := &AccessClaims{
+		UserID:    pat.UserID,
+		SessionID: personalTokenSessionID(pat.ID),
+		Scopes:    strings.Fields(pat.Scopes),
+		TokenID:   pat.ID,
+	}
+	if pat.ExpiresAt != nil {
+		claims.ExpiresAt = *pat.ExpiresAt
+	}
+	return claims, nil
+}
+
+// ルートグループに必要なスコープを確認するミドルウェア（AuthMiddleware の後に使う）
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := GetAccessClaims(c)
+		if claims == nil || !claims.HasScope(scope) {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_scope", "required_scope": scope})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 一覧・作成のレスポンス
+type personalTokenResponse struct {
+	models.PersonalAccessToken
+	ScopeList []string `json:"scopes"`
+	Token     string   `json:"token,omitempty"` // 作成時だけ平文を返す
+}
+
+func toPersonalTokenResponse(pat models.PersonalAccessToken) personalTokenResponse {
+	return personalTokenResponse{PersonalAccessToken: pat, ScopeList: strings.Fields(pat.Scopes)}
+}
+
+// ==============================
+// 🔹 アクセストークン一覧ハンドラー
+// ==============================
+// - リクエスト: GET /me/tokens
+// - 処理: 自分が発行した（自分用・ボット用の）有効なトークンを返す（平文は返さない）
+func GetPersonalTokensHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	var tokens []models.PersonalAccessToken
+	if err := db.Where("created_by = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの取得に失敗しました"})
+		return
+	}
+
+	result := make([]personalTokenResponse, 0, len(tokens))
+	for _, t := range tokens {
+		result = append(result, toPersonalTokenResponse(t))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ==============================
+// 🔹 アクセストークン発行ハンドラー
+// ==============================
+// - リクエスト: POST /me/tokens { "name": "deploy-notifier", "scopes": ["messages:write"], "expires_in_days": 90, "bot_id": 12 }
+// - 処理:
+//  1. スコープが付与可能なものか確認
+//  2. bot_id があれば自分のボット用、なければ自分用のトークンを作成
+//  3. 平文のトークンを返す（表示はこの1回だけ）
+func CreatePersonalTokenHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	var input struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"` // 0 なら無期限
+		BotID         uint     `json:"bot_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" || len(input.Name) > 255 || input.ExpiresInDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if len(input.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "スコープを1つ以上指定してください"})
+		return
+	}
+	for _, s := range input.Scopes {
+		if !grantableScopes[s] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "指定できないスコープです: " + s})
+			return
+		}
+	}
+
+	// トークンで操作するユーザー（ボットは自分が作ったものに限る）
+	owner := userID
+	if input.BotID != 0 {
+		var bot models.User
+		if err := db.Where("id = ? AND is_bot = ? AND owner_id = ?", input.BotID, true, userID).
+			First(&bot).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ボットが見つかりません"})
+			return
+		}
+		owner = bot.ID
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークン生成に失敗しました"})
+		return
+	}
+	plain := personalTokenPrefix + secret
+
+	pat := models.PersonalAccessToken{
+		UserID:    owner,
+		CreatedBy: userID,
+		Name:      input.Name,
+		TokenHash: hashToken(plain),
+		Scopes:    strings.Join(input.Scopes, " "),
+	}
+	if input.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, input.ExpiresInDays)
+		pat.ExpiresAt = &expiresAt
+	}
+	if err := db.Create(&pat).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの保存に失敗しました"})
+		return
+	}
+
+	resp := toPersonalTokenResponse(pat)
+	resp.Token = plain
+	c.JSON(http.StatusCreated, resp)
+}
+
+// ==============================
+// 🔹 アクセストークン失効ハンドラー
+// ==============================
+// - リクエスト: DELETE /me/tokens/:id
+// - 処理: 自分が発行したトークンを失効させ、そのトークンで接続中のソケットを切断する
+func DeletePersonalTokenHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	var pat models.PersonalAccessToken
+	if err := db.Where("id = ? AND created_by = ? AND revoked_at IS NULL", c.Param("id"), userID).
+		First(&pat).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "トークンが見つかりません"})
+		return
+	}
+	if err := db.Model(&pat).Update("revoked_at", time.Now()).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの失効に失敗しました"})
+		return
+	}
+	hub.DisconnectSession(pat.UserID, personalTokenSessionID(pat.ID))
+
+	c.Status(http.StatusNoContent)
+}
+
+// ==============================
+// 🔹 ボット作成ハンドラー
+// ==============================
+// - リクエスト: POST /bots { "username": "deploy-bot" }
+// - 処理: 自分が所有するボットユーザーを作る（トークンは POST /me/tokens で bot_id を指定して発行）
+func CreateBotHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	var input struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if err := validateUsername(input.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing models.User
+	if err := db.Where("username = ?", input.Username).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "このユーザー名は既に使われています"})
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "データベースエラー"})
+		return
+	}
+
+	// パスワードは持たない（ログインできない）
+	bot := models.User{Username: input.Username, IsBot: true, OwnerID: &userID}
+	if err := db.Create(&bot).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ボットの作成に失敗しました"})
+		return
+	}
+
+	log.Printf("🤖 Bot created: %d (%s) by user %d\n", bot.ID, bot.Username, userID)
+	c.JSON(http.StatusCreated, gin.H{"id": bot.ID, "username": bot.Username, "is_bot": true})
+}
+
+// ==============================
+// 🔹 ボット一覧ハンドラー
+// ==============================
+// - リクエスト: GET /bots
+// - 処理: 自分が所有するボットを返す
+func GetBotsHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	var bots []models.User
+	if err := db.Select("id", "username", "created_at").
+		Where("is_bot = ? AND owner_id = ?", true, userID).
+		Order("id").
+		Find(&bots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ボットの取得に失敗しました"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(bots))
+	for _, b := range bots {
+		result = append(result, gin.H{"id": b.ID, "username": b.Username, "is_bot": true, "created_at": b.CreatedAt})
+	}
+	c.JSON(http.StatusOK, result)
+}
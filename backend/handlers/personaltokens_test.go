@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"backend/models"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{"login token has every scope", nil, ScopeAccount, true},
+		{"granted", []string{ScopeMessagesRead, ScopeMessagesWrite}, ScopeMessagesWrite, true},
+		{"not granted", []string{ScopeMessagesRead}, ScopeMessagesWrite, false},
+		{"empty list", []string{}, ScopeMessagesRead, false},
+	}
+	for _, tt := range tests {
+		claims := &AccessClaims{Scopes: tt.scopes}
+		if got := claims.HasScope(tt.scope); got != tt.want {
+			t.Errorf("%s: HasScope(%q) = %v, want %v", tt.name, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		claims     *AccessClaims
+		wantStatus int
+	}{
+		{"no claims", nil, http.StatusForbidden},
+		{"login token", &AccessClaims{UserID: 1}, http.StatusOK},
+		{"token with the scope", &AccessClaims{UserID: 1, Scopes: []string{ScopeRoomsRead}}, http.StatusOK},
+		{"token without the scope", &AccessClaims{UserID: 1, Scopes: []string{ScopeMessagesRead}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/rooms", func(c *gin.Context) {
+				if tt.claims != nil {
+					c.Set("access_claims", tt.claims)
+				}
+			}, RequireScope(ScopeRoomsRead), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/rooms", nil))
+			expectStatus(t, w, tt.wantStatus)
+			if tt.wantStatus == http.StatusForbidden && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("WWW-Authenticate header is missing")
+			}
+		})
+	}
+}
+
+func TestPersonalTokens(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	useTestHub(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+
+	w := serveAs(alice.ID, "POST", "/bots", "/bots", map[string]string{"username": "deploy-bot"}, CreateBotHandler)
+	expectStatus(t, w, http.StatusCreated)
+	var bot struct {
+		ID uint `json:"id"`
+	}
+	decodeBody(t, w, &bot)
+	w = serveAs(bob.ID, "POST", "/bots", "/bots", map[string]string{"username": "deploy-bot"}, CreateBotHandler)
+	expectStatus(t, w, http.StatusConflict)
+
+	create := func(userID uint, body map[string]interface{}) (int, personalTokenResponse) {
+		w := serveAs(userID, "POST", "/me/tokens", "/me/tokens", body, CreatePersonalTokenHandler)
+		var res personalTokenResponse
+		if w.Code == http.StatusCreated {
+			decodeBody(t, w, &res)
+		}
+		return w.Code, res
+	}
+
+	tests := []struct {
+		name       string
+		userID     uint
+		body       map[string]interface{}
+		wantStatus int
+		wantUser   uint // トークンで操作するユーザー
+	}{
+		{"no name", alice.ID, map[string]interface{}{"scopes": []string{ScopeMessagesRead}}, http.StatusBadRequest, 0},
+		{"no scopes", alice.ID, map[string]interface{}{"name": "ci"}, http.StatusBadRequest, 0},
+		{"account scope is not grantable", alice.ID, map[string]interface{}{"name": "ci", "scopes": []string{ScopeAccount}}, http.StatusBadRequest, 0},
+		{"someone else's bot", bob.ID, map[string]interface{}{"name": "ci", "scopes": []string{ScopeMessagesRead}, "bot_id": bot.ID}, http.StatusNotFound, 0},
+		{"for myself", alice.ID, map[string]interface{}{"name": "ci", "scopes": []string{ScopeMessagesRead}}, http.StatusCreated, alice.ID},
+		{"for my bot", alice.ID, map[string]interface{}{"name": "deploy", "scopes": []string{ScopeMessagesRead, ScopeMessagesWrite}, "bot_id": bot.ID, "expires_in_days": 30}, http.StatusCreated, bot.ID},
+	}
+	var issued []personalTokenResponse
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, res := create(tt.userID, tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if status != http.StatusCreated {
+				return
+			}
+			claims, err := ParseAccessToken(res.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.UserID != tt.wantUser || fmt.Sprint(claims.Scopes) != fmt.Sprint(tt.body["scopes"]) || claims.TokenID != res.ID {
+				t.Errorf("claims = %+v", claims)
+			}
+			issued = append(issued, res)
+		})
+	}
+	if len(issued) != 2 {
+		t.Fatalf("issued %d tokens, want 2", len(issued))
+	}
+
+	// 一覧には平文を含めない
+	w = serveAs(alice.ID, "GET", "/me/tokens", "/me/tokens", nil, GetPersonalTokensHandler)
+	expectStatus(t, w, http.StatusOK)
+	var list []personalTokenResponse
+	decodeBody(t, w, &list)
+	if len(list) != 2 || list[0].Token != "" || list[1].Token != "" {
+		t.Errorf("tokens = %+v", list)
+	}
+
+	// 失効・期限切れのトークンは使えない
+	w = serveAs(bob.ID, "DELETE", "/me/tokens/:id", fmt.Sprintf("/me/tokens/%d", issued[0].ID), nil, DeletePersonalTokenHandler)
+	expectStatus(t, w, http.StatusNotFound)
+	w = serveAs(alice.ID, "DELETE", "/me/tokens/:id", fmt.Sprintf("/me/tokens/%d", issued[0].ID), nil, DeletePersonalTokenHandler)
+	expectStatus(t, w, http.StatusNoContent)
+	if _, err := ParseAccessToken(issued[0].Token); err == nil {
+		t.Error("revoked token is still accepted")
+	}
+	testDB.Model(&models.PersonalAccessToken{}).Where("id = ?", issued[1].ID).UpdateColumn("expires_at", time.Now().Add(-time.Minute))
+	if _, err := ParseAccessToken(issued[1].Token); err == nil {
+		t.Error("expired token is still accepted")
+	}
+}
+
+// ボットのトークンでメッセージを送る（スコープとルームのメンバーであることが必要）
+func TestBotSendsMessage(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bot := models.User{Username: "deploy-bot", IsBot: true, OwnerID: &alice.ID}
+	testDB.Create(&bot)
+	room := createTestRoom(t, alice.ID, bot.ID)
+	otherRoom := createTestRoom(t, alice.ID)
+
+	issue := func(scopes string) string {
+		plain := personalTokenPrefix + fmt.Sprint(time.Now().UnixNano())
+		testDB.Create(&models.PersonalAccessToken{UserID: bot.ID, CreatedBy: alice.ID, Name: "t", TokenHash: hashToken(plain), Scopes: scopes})
+		return plain
+	}
+	writeToken := issue(ScopeMessagesWrite)
+	readToken := issue(ScopeMessagesRead)
+
+	for _, ep := range sendEndpoints {
+		t.Run(ep.path, func(t *testing.T) {
+			tests := []struct {
+				name       string
+				token      string
+				room       uint
+				wantStatus int
+			}{
+				{"with messages:write", writeToken, room, http.StatusOK},
+				{"with messages:read only", readToken, room, http.StatusForbidden},
+				{"room the bot is not in", writeToken, otherRoom, http.StatusForbidden},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					body := map[string]interface{}{"room_id": tt.room, "content": "deployed"}
+					w := serveWithToken(tt.token, "POST", ep.path, ep.path, body, RequireScope(ScopeMessagesWrite), ep.handler)
+					expectStatus(t, w, tt.wantStatus)
+				})
+			}
+		})
+	}
+}
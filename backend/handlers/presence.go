@@ -0,0 +1,319 @@
+// handlers/presence.go
+package handlers
+
+import (
+	"backend/models"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// 最後の操作からこの時間が経つと away とみなす
+	presenceAwayAfter = 5 * time.Minute
+	// away 判定・共有テーブル更新の間隔
+	presenceSweepInterval = 30 * time.Second
+	// 共有テーブルの行がこの時間更新されなければ、そのサーバーは止まったとみなす
+	presenceNodeTimeout = 3 * presenceSweepInterval
+)
+
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// 接続中ユーザーの在席状況を管理する
+//   - このサーバーの接続だけをメモリで数え、状態は presences テーブルに書き込む
+//   - 全サーバーの行をまとめた状態（どこかで online なら online）を返し、その変化を通知する
+type presenceTracker struct {
+	mu         sync.Mutex
+	conns      map[uint]int       // user_id → 接続数
+	lastActive map[uint]time.Time // user_id → 最後に操作した時刻
+	status     map[uint]string    // user_id → このサーバーでの状態（接続中のみ）
+
+	// 共有テーブルへの書き込みをメモリの状態と同じ順番にする
+	// ユーザーごとに順番が守られればよいので、user_id で分けたロックを使う（他のユーザーの DB 待ちで止まらない）
+	syncMu [presenceSyncStripes]sync.Mutex
+	nodeID string // presences テーブルでこのサーバーを表す ID
+}
+
+// sync のロックの数（同じ番号に当たったユーザー同士だけが待ち合う）
+const presenceSyncStripes = 64
+
+func (p *presenceTracker) syncLock(userID uint) *sync.Mutex {
+	return &p.syncMu[userID%presenceSyncStripes]
+}
+
+var presence = &presenceTracker{
+	conns:      make(map[uint]int),
+	lastActive: make(map[uint]time.Time),
+	status:     make(map[uint]string),
+	nodeID:     newPresenceNodeID(),
+}
+
+// サーバーの ID（ホスト名 + 起動ごとの乱数。再起動したら前の行は期限切れで消える）
+func newPresenceNodeID() string {
+	host, _ := os.Hostname()
+	if len(host) > 40 {
+		host = host[:40]
+	}
+	suffix, err := randomToken(9)
+	if err != nil {
+		suffix = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return host + "-" + suffix
+}
+
+// away 判定を定期実行（main.go から呼び出される）
+func StartPresence() {
+	ticker := time.NewTicker(presenceSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		presence.sweep()
+	}
+}
+
+// WebSocket 接続時
+func (p *presenceTracker) connected(userID uint) {
+	p.mu.Lock()
+	p.conns[userID]++
+	p.lastActive[userID] = time.Now()
+	changed := p.status[userID] != PresenceOnline
+	p.status[userID] = PresenceOnline
+	p.mu.Unlock()
+
+	touchLastSeen(userID)
+	if changed {
+		p.sync(userID)
+	}
+}
+
+// WebSocket 切断時（このサーバーの最後の接続が切れたら行を消す）
+func (p *presenceTracker) disconnected(userID uint) {
+	p.mu.Lock()
+	p.conns[userID]--
+	offline := p.conns[userID] <= 0
+	if offline {
+		delete(p.conns, userID)
+		delete(p.lastActive, userID)
+		delete(p.status, userID)
+	}
+	p.mu.Unlock()
+
+	touchLastSeen(userID)
+	if offline {
+		p.sync(userID)
+	}
+}
+
+// クライアントからフレームを受け取ったとき（heartbeat を含む）
+func (p *presenceTracker) touch(userID uint) {
+	p.mu.Lock()
+	if p.conns[userID] == 0 {
+		p.mu.Unlock()
+		return
+	}
+	p.lastActive[userID] = time.Now()
+	changed := p.status[userID] == PresenceAway
+	p.status[userID] = PresenceOnline
+	p.mu.Unlock()
+
+	if changed {
+		p.sync(userID)
+	}
+}
+
+// 一定時間操作のないユーザーを away にし、共有テーブルの行を更新する
+func (p *presenceTracker) sweep() {
+	var away []uint
+
+	p.mu.Lock()
+	for userID, last := range p.lastActive {
+		if p.status[userID] == PresenceOnline && time.Since(last) >= presenceAwayAfter {
+			p.status[userID] = PresenceAway
+			away = append(away, userID)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, userID := range away {
+		touchLastSeen(userID)
+		p.sync(userID)
+	}
+
+	// このサーバーの行がまだ生きていることを知らせる
+	if err := db.Model(&models.Presence{}).
+		Where("node_id = ?", p.nodeID).
+		UpdateColumn("updated_at", time.Now()).Error; err != nil {
+		log.Println("❌ presence heartbeat error:", err)
+	}
+	p.reapStaleNodes()
+}
+
+// 止まったサーバーの行を消し、それでオフラインになったユーザーを通知する
+// （DELETE ... RETURNING なので、複数サーバーが同時に実行しても通知は1回だけ）
+func (p *presenceTracker) reapStaleNodes() {
+	var userIDs []uint
+	if err := db.Raw("DELETE FROM presences WHERE updated_at < ? RETURNING user_id",
+		time.Now().Add(-presenceNodeTimeout)).Scan(&userIDs).Error; err != nil {
+		log.Println("❌ presence cleanup error:", err)
+		return
+	}
+
+	seen := make(map[uint]bool)
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		if p.getMany([]uint{userID})[userID] == PresenceOffline {
+			notifyPresence(userID, PresenceOffline)
+		}
+	}
+}
+
+// このサーバーでの状態を共有テーブルに書き込み、全体の状態が変わったら通知する
+func (p *presenceTracker) sync(userID uint) {
+	mu := p.syncLock(userID)
+	mu.Lock()
+	defer mu.Unlock()
+
+	before := p.getMany([]uint{userID})[userID]
+
+	p.mu.Lock()
+	status, connected := p.status[userID]
+	p.mu.Unlock()
+
+	var err error
+	if connected {
+		err = db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "node_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"status", "updated_at"}),
+		}).Create(&models.Presence{NodeID: p.nodeID, UserID: userID, Status: status}).Error
+	} else {
+		err = db.Where("node_id = ? AND user_id = ?", p.nodeID, userID).Delete(&models.Presence{}).Error
+	}
+	if err != nil {
+		log.Println("❌ presence update error:", err)
+		return
+	}
+
+	if after := p.getMany([]uint{userID})[userID]; after != before {
+		notifyPresence(userID, after)
+	}
+}
+
+// 全サーバーをまとめた状態（どこかで online なら online、接続があるのが away だけなら away、なければ offline）
+func (p *presenceTracker) getMany(userIDs []uint) map[uint]string {
+	result := make(map[uint]string, len(userIDs))
+	for _, id := range userIDs {
+		result[id] = PresenceOffline
+	}
+
+	var rows []models.Presence
+	if err := db.Select("user_id", "status").
+		Where("user_id IN ? AND updated_at > ?", userIDs, time.Now().Add(-presenceNodeTimeout)).
+		Find(&rows).Error; err != nil {
+		log.Println("❌ presence fetch error:", err)
+		return result
+	}
+	for _, r := range rows {
+		if r.Status == PresenceOnline || result[r.UserID] == PresenceOffline {
+			result[r.UserID] = r.Status
+		}
+	}
+	return result
+}
+
+// users.last_seen_at を現在時刻に更新
+func touchLastSeen(userID uint) {
+	if err := db.Model(&models.User{}).
+		Where("id = ?", userID).
+		UpdateColumn("last_seen_at", time.Now()).Error; err != nil {
+		log.Println("❌ last_seen_at update error:", err)
+	}
+}
+
+// 同じルームに所属するユーザーへ presence イベントを送る
+func notifyPresence(userID uint, status string) {
+	var peerIDs []uint
+	if err := db.Raw(`
+		SELECT DISTINCT rm2.user_id FROM room_members rm1
+		JOIN room_members rm2 ON rm2.room_id = rm1.room_id AND rm2.deleted_at IS NULL
+		WHERE rm1.user_id = ? AND rm1.deleted_at IS NULL AND rm2.user_id != ?
+	`, userID, userID).Scan(&peerIDs).Error; err != nil {
+		log.Println("❌ presence peers fetch error:", err)
+		return
+	}
+	if len(peerIDs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	hub.SendToUsers(peerIDs, models.PresenceNotification{
+		Type:       "presence",
+		UserID:     userID,
+		Status:     status,
+		LastSeenAt: &now,
+	})
+}
+
+// GET /users/presence?ids=1,2,3
+// 自分と同じルームに所属するユーザー（と自分）の分だけを返す（それ以外の ID は結果に含めない）
+func GetPresenceHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+	idsStr := c.Query("ids")
+	if idsStr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids is required"})
+		return
+	}
+
+	var ids []uint
+	for _, s := range strings.Split(idsStr, ",") {
+		id := parseUint(strings.TrimSpace(s))
+		if id == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ids"})
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	var users []models.User
+	if err := db.Select("id", "last_seen_at").
+		Where("id IN ?", ids).
+		Where(`id = ? OR id IN (
+			SELECT rm2.user_id FROM room_members rm1
+			JOIN room_members rm2 ON rm2.room_id = rm1.room_id AND rm2.deleted_at IS NULL
+			WHERE rm1.user_id = ? AND rm1.deleted_at IS NULL
+		)`, userID, userID).
+		Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
+		return
+	}
+
+	found := make([]uint, 0, len(users))
+	for _, u := range users {
+		found = append(found, u.ID)
+	}
+	statuses := presence.getMany(found)
+
+	result := []models.PresenceNotification{}
+	for _, u := range users {
+		result = append(result, models.PresenceNotification{
+			Type:       "presence",
+			UserID:     u.ID,
+			Status:     statuses[u.ID],
+			LastSeenAt: u.LastSeenAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
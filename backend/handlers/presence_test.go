@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"backend/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestPresenceTracker(nodeID string) *presenceTracker {
+	return &presenceTracker{
+		conns:      make(map[uint]int),
+		lastActive: make(map[uint]time.Time),
+		status:     make(map[uint]string),
+		nodeID:     nodeID,
+	}
+}
+
+// 1人の sync が DB を待っていても、別のロックに当たるユーザーは待たされない
+func TestPresenceSyncLock(t *testing.T) {
+	p := newTestPresenceTracker("node-a")
+	held := p.syncLock(1)
+	held.Lock()
+	defer held.Unlock()
+
+	tests := []struct {
+		userID   uint
+		wantFree bool
+	}{
+		{1, false},
+		{2, true},
+		{presenceSyncStripes, true},
+		{1 + presenceSyncStripes, false}, // 同じ番号に当たる
+	}
+	for _, tt := range tests {
+		mu := p.syncLock(tt.userID)
+		free := mu.TryLock()
+		if free {
+			mu.Unlock()
+		}
+		if free != tt.wantFree {
+			t.Errorf("user %d: lock free = %v, want %v", tt.userID, free, tt.wantFree)
+		}
+	}
+}
+
+func TestPresenceTransitions(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	p := newTestPresenceTracker("node-a")
+
+	tests := []struct {
+		name string
+		step func()
+		want string
+	}{
+		{"before connecting", func() {}, PresenceOffline},
+		{"connected", func() { p.connected(alice.ID) }, PresenceOnline},
+		{"second connection", func() { p.connected(alice.ID) }, PresenceOnline},
+		{"idle", func() {
+			p.mu.Lock()
+			p.lastActive[alice.ID] = time.Now().Add(-presenceAwayAfter)
+			p.mu.Unlock()
+			p.sweep()
+		}, PresenceAway},
+		{"active again", func() { p.touch(alice.ID) }, PresenceOnline},
+		{"one connection closed", func() { p.disconnected(alice.ID) }, PresenceOnline},
+		{"last connection closed", func() { p.disconnected(alice.ID) }, PresenceOffline},
+	}
+	for _, tt := range tests {
+		tt.step()
+		if got := p.getMany([]uint{alice.ID})[alice.ID]; got != tt.want {
+			t.Fatalf("%s: status = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// 接続・切断で last_seen_at が記録される
+	var user models.User
+	testDB.First(&user, alice.ID)
+	if user.LastSeenAt == nil {
+		t.Error("last_seen_at was not recorded")
+	}
+}
+
+func TestGetPresenceHandler(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	createTestRoom(t, alice.ID, bob.ID)
+	createTestRoom(t, carol.ID)
+
+	presence.connected(bob.ID)
+	presence.connected(carol.ID)
+	t.Cleanup(func() {
+		presence.disconnected(bob.ID)
+		presence.disconnected(carol.ID)
+	})
+
+	tests := []struct {
+		name       string
+		ids        string
+		wantStatus int
+		want       map[uint]string // 返ってくるユーザーとその状態
+	}{
+		{"ids required", "", http.StatusBadRequest, nil},
+		{"invalid id", fmt.Sprintf("%d,x", bob.ID), http.StatusBadRequest, nil},
+		{"room peer", fmt.Sprint(bob.ID), http.StatusOK, map[uint]string{bob.ID: PresenceOnline}},
+		{"self", fmt.Sprint(alice.ID), http.StatusOK, map[uint]string{alice.ID: PresenceOffline}},
+		{"user in no shared room is omitted", fmt.Sprintf("%d,%d", bob.ID, carol.ID), http.StatusOK, map[uint]string{bob.ID: PresenceOnline}},
+		{"unknown user is omitted", "9999", http.StatusOK, map[uint]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(alice.ID, "GET", "/users/presence", "/users/presence?ids="+tt.ids, nil, GetPresenceHandler)
+			expectStatus(t, w, tt.wantStatus)
+			if tt.want == nil {
+				return
+			}
+
+			var got []models.PresenceNotification
+			decodeBody(t, w, &got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, want %v", got, tt.want)
+			}
+			for _, n := range got {
+				if status, ok := tt.want[n.UserID]; !ok || n.Status != status {
+					t.Errorf("user %d: status = %q, want %v", n.UserID, n.Status, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// 複数サーバーに接続があるときは、すべてのサーバーの状態をまとめて返す
+func TestPresenceAcrossNodes(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	a := newTestPresenceTracker("node-a")
+	b := newTestPresenceTracker("node-b")
+
+	tests := []struct {
+		name string
+		step func()
+		want string
+	}{
+		{"connected on node a", func() { a.connected(alice.ID) }, PresenceOnline},
+		{"connected on node b", func() { b.connected(alice.ID) }, PresenceOnline},
+		{"idle on node a", func() {
+			a.mu.Lock()
+			a.lastActive[alice.ID] = time.Now().Add(-presenceAwayAfter)
+			a.mu.Unlock()
+			a.sweep()
+		}, PresenceOnline},
+		{"closed on node b", func() { b.disconnected(alice.ID) }, PresenceAway},
+		{"reconnected on node b", func() { b.connected(alice.ID) }, PresenceOnline},
+		// 応答のなくなったサーバーの行は数えず、いずれ他のサーバーが削除する
+		{"node b stopped", func() {
+			testDB.Model(&models.Presence{}).Where("node_id = ?", "node-b").
+				UpdateColumn("updated_at", time.Now().Add(-presenceNodeTimeout))
+		}, PresenceAway},
+		{"closed on node a", func() { a.disconnected(alice.ID) }, PresenceOffline},
+	}
+	for _, tt := range tests {
+		tt.step()
+		if got := a.getMany([]uint{alice.ID})[alice.ID]; got != tt.want {
+			t.Fatalf("%s: status = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	a.reapStaleNodes()
+	var count int64
+	testDB.Model(&models.Presence{}).Where("node_id = ?", "node-b").Count(&count)
+	if count != 0 {
+		t.Errorf("stale rows of node b = %d, want 0", count)
+	}
+}
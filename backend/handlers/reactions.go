@@ -0,0 +1,241 @@
+// handlers/reactions.go
+package handlers
+
+import (
+	"backend/models"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxReactionEmojiBytes = 64 // message_reactions.emoji の長さ
+	maxReactionsPerUser   = 20 // 1人が1つのメッセージに付けられる絵文字の種類
+)
+
+var errTooManyReactions = errors.New("too many reactions on this message")
+
+// カスタム絵文字のショートコード（例: :party_parrot:）
+var reactionShortcode = regexp.MustCompile(`^:[a-z0-9_+\-]{1,32}:$`)
+
+// リアクションに使える文字列か
+// Unicode の絵文字（ZWJ でつないだもの・肌の色・旗・キーキャップを含む）かショートコードに限る
+func validReactionEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxReactionEmojiBytes || !utf8.ValidString(emoji) {
+		return false
+	}
+	if reactionShortcode.MatchString(emoji) {
+		return true
+	}
+
+	hasSymbol := false
+	for _, r := range emoji {
+		switch {
+		case unicode.Is(unicode.So, r), r == 0x20E3: // 絵文字・キーキャップの囲み
+			hasSymbol = true
+		case unicode.In(r, unicode.Mn, unicode.Sk): // 肌の色など
+		case r == 0x200D, r == 0xFE0F: // ZWJ・異体字セレクタ
+		case r >= 0xE0020 && r <= 0xE007F: // タグ（地域の旗）
+		case r >= '0' && r <= '9', r == '#', r == '*': // キーキャップの数字・記号
+		default:
+			return false
+		}
+	}
+	return hasSymbol
+}
+
+// メッセージごとのリアクション集計（絵文字ごとの数と、自分が付けているか）
+func loadReactionCounts(messageIDs []uint, userID uint) (map[uint][]models.ReactionCount, error) {
+	var rows []struct {
+		MessageID uint
+		models.ReactionCount
+	}
+	if err := db.Model(&models.MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS me", userID).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("MIN(created_at), emoji").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	result := make(map[uint][]models.ReactionCount)
+	for _, r := range rows {
+		result[r.MessageID] = append(result[r.MessageID], r.ReactionCount)
+	}
+	return result, nil
+}
+
+// ページ内のメッセージにリアクションの集計を付ける
+func fillReactions(messages []models.MessageWithRead, userID uint) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+
+	counts, err := loadReactionCounts(ids, userID)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Reactions = counts[messages[i].ID]
+		if messages[i].Reactions == nil {
+			messages[i].Reactions = []models.ReactionCount{}
+		}
+	}
+	return nil
+}
+
+// リアクション操作の対象メッセージを読み、ルームのメンバーか確認する
+// 問題があればレスポンスを書いて ok=false を返す
+func reactionTarget(c *gin.Context, userID uint) (msg models.Message, emoji string, ok bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+		return msg, "", false
+	}
+	emoji = c.Param("emoji")
+	if !validReactionEmoji(emoji) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid emoji"})
+		return msg, "", false
+	}
+	if err := db.Select("id", "room_id").First(&msg, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return msg, "", false
+	}
+	if !isRoomMember(db, msg.RoomID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+		return msg, "", false
+	}
+	return msg, emoji, true
+}
+
+// 変更をルームに通知し、そのメッセージの集計を返す
+func respondReactionChange(c *gin.Context, msg models.Message, userID uint, emoji, action string, changed bool) {
+	counts, err := loadReactionCounts([]uint{msg.ID}, userID)
+	if err != nil {
+		log.Println("❌ リアクション集計失敗:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get reactions"})
+		return
+	}
+	reactions := counts[msg.ID]
+	if reactions == nil {
+		reactions = []models.ReactionCount{}
+	}
+
+	// 実際に増減したときだけ通知する（同じ操作の繰り返しでは送らない）
+	if changed {
+		var count int64
+		for _, r := range reactions {
+			if r.Emoji == emoji {
+				count = r.Count
+			}
+		}
+		BroadcastToRoom(msg.RoomID, models.ReactionNotification{
+			Type:      "reaction",
+			Action:    action,
+			MessageID: msg.ID,
+			RoomID:    msg.RoomID,
+			UserID:    userID,
+			Emoji:     emoji,
+			Count:     count,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message_id": msg.ID, "reactions": reactions})
+}
+
+// ==============================
+// 🔹 リアクション追加ハンドラー
+// ==============================
+// - リクエスト: POST /messages/:id/reactions/:emoji（絵文字は URL エンコードする）
+// - 処理:
+//  1. 絵文字とメッセージ、ルームのメンバーかを確認
+//  2. リアクションを保存（既に付けていれば何もしない）
+//  3. ルームに reaction イベントを送り、そのメッセージの集計を返す
+func AddReactionHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	msg, emoji, ok := reactionTarget(c, userID)
+	if !ok {
+		return
+	}
+
+	// 1人が付けられる種類に上限を設ける（既に付けている絵文字は数えない）
+	// 同時に追加されても上限を超えないよう、メッセージの行をロックしてから数えて保存する
+	var added bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var locked models.Message
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			First(&locked, msg.ID).Error; err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.MessageReaction{}).
+			Where("message_id = ? AND user_id = ? AND emoji <> ?", msg.ID, userID, emoji).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= maxReactionsPerUser {
+			return errTooManyReactions
+		}
+
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "message_id"}, {Name: "user_id"}, {Name: "emoji"}},
+			DoNothing: true,
+		}).Create(&models.MessageReaction{MessageID: msg.ID, UserID: userID, Emoji: emoji})
+		added = result.RowsAffected > 0
+		return result.Error
+	})
+	switch {
+	case errors.Is(err, errTooManyReactions):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	case err != nil:
+		log.Println("❌ リアクション保存失敗:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add reaction"})
+		return
+	}
+
+	respondReactionChange(c, msg, userID, emoji, "added", added)
+}
+
+// ==============================
+// 🔹 リアクション削除ハンドラー
+// ==============================
+// - リクエスト: DELETE /messages/:id/reactions/:emoji
+// - 処理: 自分が付けたリアクションを外し、ルームに reaction イベントを送る（付けていなければ何もしない）
+func RemoveReactionHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	msg, emoji, ok := reactionTarget(c, userID)
+	if !ok {
+		return
+	}
+
+	result := db.Where("message_id = ? AND user_id = ? AND emoji = ?", msg.ID, userID, emoji).
+		Delete(&models.MessageReaction{})
+	if result.Error != nil {
+		log.Println("❌ リアクション削除失敗:", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove reaction"})
+		return
+	}
+
+	respondReactionChange(c, msg, userID, emoji, "removed", result.RowsAffected > 0)
+}
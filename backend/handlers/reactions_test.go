@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"backend/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidReactionEmoji(t *testing.T) {
+	tests := []struct {
+		emoji string
+		want  bool
+	}{
+		{"👍", true},
+		{"❤️", true},             // 異体字セレクタ付き
+		{"👍🏽", true},             // 肌の色
+		{"👩‍💻", true},            // ZWJ
+		{"🇯🇵", true},             // 地域指示記号
+		{"🏴󠁧󠁢󠁳󠁣󠁴󠁿", true},        // タグの旗
+		{"1️⃣", true},            // キーキャップ
+		{":party_parrot:", true}, // ショートコード
+		{":+1:", true},
+		{":Party:", false}, // 大文字
+		{"::", false},
+		{"", false},
+		{"a", false},
+		{"1", false},  // キーキャップの囲みがない数字
+		{"👍 ", false}, // 空白
+		{"<script>", false},
+		{strings.Repeat("👍", 17), false}, // 68 バイト
+		{"\xff", false},
+	}
+	for _, tt := range tests {
+		if got := validReactionEmoji(tt.emoji); got != tt.want {
+			t.Errorf("validReactionEmoji(%q) = %v, want %v", tt.emoji, got, tt.want)
+		}
+	}
+}
+
+func TestReactions(t *testing.T) {
+	setupTestDB(t)
+	h := useTestHub(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	room := createTestRoom(t, alice.ID, bob.ID)
+	msg := createTestMessage(t, room, alice.ID, "hello")
+	peer := connectTestClient(h, bob.ID, room, flushRoom)
+
+	reaction := func(method string, userID uint, id, emoji string) (int, []models.ReactionCount) {
+		handler := AddReactionHandler
+		if method == "DELETE" {
+			handler = RemoveReactionHandler
+		}
+		path := fmt.Sprintf("/messages/%s/reactions/%s", id, url.PathEscape(emoji))
+		w := serveAs(userID, method, "/messages/:id/reactions/:emoji", path, nil, handler)
+		var body struct {
+			Reactions []models.ReactionCount `json:"reactions"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body.Reactions
+	}
+	id := fmt.Sprint(msg.ID)
+
+	tests := []struct {
+		name       string
+		method     string
+		userID     uint
+		id         string
+		emoji      string
+		wantStatus int
+		wantCount  int64 // 操作した絵文字の数
+		wantMe     bool
+		wantEvent  string // ルームに届く reaction イベントの action（届かなければ空）
+	}{
+		{"invalid message id", "POST", alice.ID, "abc", "👍", http.StatusBadRequest, 0, false, ""},
+		{"invalid emoji", "POST", alice.ID, id, "abc", http.StatusBadRequest, 0, false, ""},
+		{"unknown message", "POST", alice.ID, "99999", "👍", http.StatusNotFound, 0, false, ""},
+		{"not a member", "POST", carol.ID, id, "👍", http.StatusForbidden, 0, false, ""},
+		{"add", "POST", alice.ID, id, "👍", http.StatusOK, 1, true, "added"},
+		{"add again", "POST", alice.ID, id, "👍", http.StatusOK, 1, true, ""},
+		{"add by another user", "POST", bob.ID, id, "👍", http.StatusOK, 2, true, "added"},
+		{"remove", "DELETE", bob.ID, id, "👍", http.StatusOK, 1, false, "removed"},
+		{"remove again", "DELETE", bob.ID, id, "👍", http.StatusOK, 1, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reactions := reaction(tt.method, tt.userID, tt.id, tt.emoji)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if status == http.StatusOK {
+				var got models.ReactionCount
+				for _, r := range reactions {
+					if r.Emoji == tt.emoji {
+						got = r
+					}
+				}
+				if got.Count != tt.wantCount || got.Me != tt.wantMe {
+					t.Errorf("reaction = %+v, want count %d me %v", got, tt.wantCount, tt.wantMe)
+				}
+			}
+
+			frames := framesUntilFlush(t, h, peer)
+			switch {
+			case tt.wantEvent == "" && len(frames) != 0:
+				t.Errorf("frames = %v, want none", frames)
+			case tt.wantEvent != "":
+				var ev models.ReactionNotification
+				if len(frames) == 1 {
+					json.Unmarshal([]byte(frames[0]), &ev)
+				}
+				if ev.Type != "reaction" || ev.Action != tt.wantEvent || ev.Emoji != tt.emoji || ev.Count != tt.wantCount {
+					t.Errorf("frames = %v, want %s event", frames, tt.wantEvent)
+				}
+			}
+		})
+	}
+
+	// 1人が付けられる種類には上限がある（既に付けている絵文字は数えない）
+	for i := 1; i < maxReactionsPerUser; i++ {
+		if status, _ := reaction("POST", alice.ID, id, fmt.Sprintf(":e%d:", i)); status != http.StatusOK {
+			t.Fatalf("reaction %d: status = %d", i, status)
+		}
+	}
+	if status, _ := reaction("POST", alice.ID, id, ":one_more:"); status != http.StatusBadRequest {
+		t.Errorf("over the limit: status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if status, _ := reaction("POST", alice.ID, id, "👍"); status != http.StatusOK {
+		t.Errorf("existing reaction: status = %d, want %d", status, http.StatusOK)
+	}
+	if status, _ := reaction("POST", bob.ID, id, ":one_more:"); status != http.StatusOK {
+		t.Errorf("another user: status = %d, want %d", status, http.StatusOK)
+	}
+}
@@ -0,0 +1,229 @@
+// handlers/replay.go
+package handlers
+
+import (
+	"backend/models"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 再接続時に再送するイベント数の上限（超えたら REST で取り直してもらう）
+const maxReplayEvents = 500
+
+// 再送したメッセージがライブ配信でも届いたら捨てる期間
+// （ハブへの登録から再送のクエリまでの間に確定したメッセージは、両方に含まれる）
+const replayDedupeWindow = 30 * time.Second
+
+// 再送するイベント（発生時刻順に並べて送る）
+type replayEvent struct {
+	at      time.Time
+	order   int // 同時刻のときの並び順（message → update → delete → read）
+	id      uint
+	payload interface{}
+}
+
+var errTooManyReplayEvents = errors.New("too many events to replay")
+
+// クエリから再開位置を読む
+//   - last_message_id=123          … 全ルーム共通のカーソル
+//   - cursors=5:120,8:98           … ルームごとのカーソル（room_id:message_id）
+//
+// 戻り値は room_id → 最後に受け取った message_id（0 はルーム共通カーソル）
+func parseReplayCursors(lastMessageID, cursors string) (map[uint]uint, error) {
+	result := make(map[uint]uint)
+	if lastMessageID != "" {
+		id, err := strconv.ParseUint(lastMessageID, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid last_message_id")
+		}
+		result[0] = uint(id)
+	}
+	if cursors == "" {
+		return result, nil
+	}
+	for _, pair := range strings.Split(cursors, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid cursors")
+		}
+		roomID, err1 := strconv.ParseUint(parts[0], 10, 64)
+		msgID, err2 := strconv.ParseUint(parts[1], 10, 64)
+		if err1 != nil || err2 != nil || roomID == 0 {
+			return nil, errors.New("invalid cursors")
+		}
+		result[uint(roomID)] = uint(msgID)
+	}
+	return result, nil
+}
+
+// カーソル以降に起きたメッセージ・編集・削除・既読を DB から集めて時刻順に返す
+func collectReplayEvents(db *gorm.DB, rooms map[uint]bool, cursors map[uint]uint) ([]interface{}, error) {
+	var events []replayEvent
+
+	for roomID := range rooms {
+		lastID, ok := cursors[roomID]
+		if !ok {
+			lastID, ok = cursors[0]
+		}
+		if !ok {
+			continue
+		}
+
+		// カーソルのメッセージの作成時刻より後の変更を対象にする
+		var since time.Time
+		var cursorMsg models.Message
+		if err := db.Unscoped().Select("created_at").First(&cursorMsg, lastID).Error; err == nil {
+			since = cursorMsg.CreatedAt
+		}
+
+		// 新着メッセージ
+		var messages []models.Message
+		if err := db.Preload("Attachments").
+			Where("room_id = ? AND id > ?", roomID, lastID).
+			Order("created_at ASC, id ASC").
+			Limit(maxReplayEvents + 1).
+			Find(&messages).Error; err != nil {
+			return nil, err
+		}
+		for _, m := range messages {
+			events = append(events, replayEvent{m.CreatedAt, 0, m.ID, toWSMessage(m, m.Attachments)})
+		}
+
+		// カーソルの時刻が分からない（カーソルのメッセージがない）ときは、
+		// 既に受け取ったメッセージへの変更を判定できないので新着だけを送る
+		if since.IsZero() {
+			if len(events) > maxReplayEvents {
+				return nil, errTooManyReplayEvents
+			}
+			continue
+		}
+
+		// 既に受け取ったメッセージの編集（本文の編集だけ。updated_at は他の更新でも変わる）
+		var edited []models.Message
+		if err := db.Where("room_id = ? AND id <= ? AND edited_at > ?", roomID, lastID, since).
+			Limit(maxReplayEvents + 1).
+			Find(&edited).Error; err != nil {
+			return nil, err
+		}
+		for _, m := range edited {
+			events = append(events, replayEvent{*m.EditedAt, 1, m.ID, updateEvent(m)})
+		}
+
+		// 既に受け取ったメッセージの削除
+		var deleted []models.Message
+		if err := db.Unscoped().
+			Where("room_id = ? AND id <= ? AND deleted_at > ?", roomID, lastID, since).
+			Limit(maxReplayEvents + 1).
+			Find(&deleted).Error; err != nil {
+			return nil, err
+		}
+		for _, m := range deleted {
+			events = append(events, replayEvent{m.DeletedAt.Time, 2, m.ID, deleteEvent(m)})
+		}
+
+		// 既読
+		var reads []models.MessageRead
+		if err := db.Joins("JOIN messages ON messages.id = message_reads.message_id").
+			Where("messages.room_id = ? AND message_reads.read_at > ?", roomID, since).
+			Limit(maxReplayEvents + 1).
+			Find(&reads).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range reads {
+			events = append(events, replayEvent{r.ReadAt, 3, r.MessageID, models.ReadNotification{
+				Type:      "read",
+				MessageID: r.MessageID,
+				UserID:    r.UserID,
+				RoomID:    roomID,
+			}})
+		}
+
+		if len(events) > maxReplayEvents {
+			return nil, errTooManyReplayEvents
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].at.Equal(events[j].at) {
+			return events[i].at.Before(events[j].at)
+		}
+		if events[i].order != events[j].order {
+			return events[i].order < events[j].order
+		}
+		return events[i].id < events[j].id
+	})
+
+	payloads := make([]interface{}, 0, len(events))
+	for _, e := range events {
+		payloads = append(payloads, e.payload)
+	}
+	return payloads, nil
+}
+
+// カーソル以降のイベントを write で順に送り、最後に resumed を送る
+// （WebSocket では writePump 開始前、SSE ではライブ配信前にだけ呼ぶ）
+// 戻り値は再送したメッセージの ID（ライブ配信との重複を捨てるのに使う）
+func replayMissedEvents(db *gorm.DB, userID uint, rooms map[uint]bool, cursors map[uint]uint, write func(payload interface{}) error) map[uint]bool {
+	events, err := collectReplayEvents(db, rooms, cursors)
+	if err != nil {
+		// 取りこぼしが多すぎる・DBエラーのときは REST で取り直してもらう
+		log.Printf("⚠️ Replay skipped for user %d: %v\n", userID, err)
+		write(map[string]interface{}{"type": "resync_required"})
+		return nil
+	}
+
+	replayed := make(map[uint]bool)
+	for _, e := range events {
+		if err := write(e); err != nil {
+			log.Println("❌ Replay write error:", err)
+			return replayed
+		}
+		if m, ok := e.(models.WSMessage); ok {
+			replayed[m.ID] = true
+		}
+	}
+	write(map[string]interface{}{"type": "resumed", "replayed": len(events)})
+	log.Printf("⏪ Replayed %d events to user %d\n", len(events), userID)
+	return replayed
+}
+
+// 再送したメッセージを覚えておく（送信キューを読み始める前に呼ぶ）
+func (c *Client) markReplayed(ids map[uint]bool) {
+	if len(ids) == 0 {
+		return
+	}
+	c.replayed = ids
+	c.replayedUntil = time.Now().Add(replayDedupeWindow)
+}
+
+// 再送済みのメッセージと同じライブイベントなら true（送らずに捨てる）
+// 同じメッセージが重ねて届くのは1回だけなので、捨てたら忘れる
+func (c *Client) skipReplayed(data []byte) bool {
+	if len(c.replayed) == 0 {
+		return false
+	}
+	if time.Now().After(c.replayedUntil) {
+		c.replayed = nil
+		return false
+	}
+
+	var head struct {
+		Type string `json:"type"`
+		ID   uint   `json:"id"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return false
+	}
+	if (head.Type != "message" && head.Type != "thread_reply") || !c.replayed[head.ID] {
+		return false
+	}
+	delete(c.replayed, head.ID)
+	return true
+}
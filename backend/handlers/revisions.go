@@ -0,0 +1,76 @@
+// handlers/revisions.go
+package handlers
+
+import (
+	"backend/models"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 送信後に編集できる期間（環境変数で変更可能）
+var messageEditWindow = envDuration("MESSAGE_EDIT_WINDOW", 24*time.Hour)
+
+var (
+	errNotMessageSender  = errors.New("only the sender can edit this message")
+	errEditWindowExpired = errors.New("edit window has expired")
+)
+
+// メッセージを編集できるか（送信者本人が、編集期間内に限る）
+func checkEditable(msg models.Message, userID uint) error {
+	if msg.SenderID != userID {
+		return errNotMessageSender
+	}
+	if time.Since(msg.CreatedAt) > messageEditWindow {
+		return errEditWindowExpired
+	}
+	return nil
+}
+
+// ==============================
+// 🔹 編集履歴取得ハンドラー
+// ==============================
+// - リクエスト: GET /messages/:id/history
+// - 処理:
+//  1. メッセージのルームのメンバーか確認
+//  2. 編集履歴（編集者・時刻・編集前後の本文）を古い順に返す
+func GetMessageHistoryHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+		return
+	}
+
+	var msg models.Message
+	if err := db.First(&msg, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	if !isRoomMember(db, msg.RoomID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+		return
+	}
+
+	revisions := []models.MessageRevision{}
+	if err := db.Where("message_id = ?", msg.ID).
+		Order("created_at ASC, id ASC").
+		Find(&revisions).Error; err != nil {
+		log.Println("❌ 編集履歴取得失敗:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message_id": msg.ID,
+		"content":    msg.Content,
+		"created_at": msg.CreatedAt,
+		"edited_at":  msg.EditedAt,
+		"revisions":  revisions,
+	})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"backend/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCheckEditable(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		msg     models.Message
+		userID  uint
+		wantErr error
+	}{
+		{"sender within the window", models.Message{SenderID: 1, CreatedAt: now.Add(-time.Minute)}, 1, nil},
+		{"other user", models.Message{SenderID: 1, CreatedAt: now}, 2, errNotMessageSender},
+		{"window expired", models.Message{SenderID: 1, CreatedAt: now.Add(-messageEditWindow - time.Minute)}, 1, errEditWindowExpired},
+		{"other user after the window", models.Message{SenderID: 1, CreatedAt: now.Add(-messageEditWindow - time.Minute)}, 2, errNotMessageSender},
+	}
+	for _, tt := range tests {
+		if err := checkEditable(tt.msg, tt.userID); err != tt.wantErr {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMessageEditHistory(t *testing.T) {
+	setupTestDB(t)
+	useTestHub(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	room := createTestRoom(t, alice.ID, bob.ID)
+	msg := createTestMessage(t, room, alice.ID, "v1")
+	expired := models.Message{RoomID: room, SenderID: alice.ID, Content: "old", CreatedAt: time.Now().Add(-messageEditWindow - time.Minute)}
+	testDB.Create(&expired)
+
+	edit := func(userID, id uint, content string) int {
+		path := fmt.Sprintf("/messages/%d", id)
+		return serveAs(userID, "PATCH", "/messages/:id", path, map[string]string{"content": content}, UpdateMessageHandler(testDB)).Code
+	}
+	tests := []struct {
+		name       string
+		userID     uint
+		id         uint
+		content    string
+		wantStatus int
+	}{
+		{"empty content", alice.ID, msg.ID, " ", http.StatusBadRequest},
+		{"unknown message", alice.ID, 99999, "v2", http.StatusNotFound},
+		{"not the sender", bob.ID, msg.ID, "v2", http.StatusForbidden},
+		{"window expired", alice.ID, expired.ID, "new", http.StatusForbidden},
+		{"first edit", alice.ID, msg.ID, "v2", http.StatusOK},
+		{"same content", alice.ID, msg.ID, "v2", http.StatusOK},
+		{"second edit", alice.ID, msg.ID, "v3", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := edit(tt.userID, tt.id, tt.content); got != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.wantStatus)
+		}
+	}
+
+	// 編集履歴は編集ごとに1件（本文が変わらない編集は残らない）
+	historyTests := []struct {
+		name       string
+		userID     uint
+		path       string
+		wantStatus int
+	}{
+		{"member", bob.ID, fmt.Sprintf("/messages/%d/history", msg.ID), http.StatusOK},
+		{"not a member", carol.ID, fmt.Sprintf("/messages/%d/history", msg.ID), http.StatusForbidden},
+		{"invalid id", alice.ID, "/messages/abc/history", http.StatusBadRequest},
+		{"unknown message", alice.ID, "/messages/99999/history", http.StatusNotFound},
+	}
+	for _, tt := range historyTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(tt.userID, "GET", "/messages/:id/history", tt.path, nil, GetMessageHistoryHandler)
+			expectStatus(t, w, tt.wantStatus)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Content   string                   `json:"content"`
+				EditedAt  *time.Time               `json:"edited_at"`
+				Revisions []models.MessageRevision `json:"revisions"`
+			}
+			decodeBody(t, w, &body)
+			if body.Content != "v3" || body.EditedAt == nil {
+				t.Errorf("content = %q, edited_at = %v", body.Content, body.EditedAt)
+			}
+			want := [][2]string{{"v1", "v2"}, {"v2", "v3"}}
+			if len(body.Revisions) != len(want) {
+				t.Fatalf("revisions = %+v", body.Revisions)
+			}
+			for i, r := range body.Revisions {
+				if r.OldContent != want[i][0] || r.NewContent != want[i][1] || r.EditorID != alice.ID {
+					t.Errorf("revision %d = %+v", i, r)
+				}
+			}
+		})
+	}
+}
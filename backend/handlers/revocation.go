@@ -0,0 +1,107 @@
+// handlers/revocation.go
+package handlers
+
+import (
+	"backend/models"
+	"log"
+	"time"
+)
+
+// アクセストークンの失効リスト（DB に保存するので複数サーバーでも共有される）
+type revocationStore struct{}
+
+var revocations = &revocationStore{}
+
+// トークン自身、またはトークンのセッションが失効していれば true
+func (r *revocationStore) isRevoked(claims *AccessClaims) bool {
+	if claims.JTI == "" && claims.SessionID == "" {
+		return false
+	}
+
+	q := db.Model(&models.RevokedToken{}).Where("expires_at > ?", time.Now())
+	switch {
+	case claims.JTI != "" && claims.SessionID != "":
+		q = q.Where("jti = ? OR session_id = ?", claims.JTI, claims.SessionID)
+	case claims.JTI != "":
+		q = q.Where("jti = ?", claims.JTI)
+	default:
+		q = q.Where("session_id = ?", claims.SessionID)
+	}
+
+	var count int64
+	if err := q.Count(&count).Error; err != nil {
+		// 確認できないときは安全側に倒す
+		log.Println("❌ revocation check error:", err)
+		return true
+	}
+	return count > 0
+}
+
+// 1つのトークンを有効期限まで失効させる
+func (r *revocationStore) revokeToken(claims *AccessClaims) error {
+	return r.add(models.RevokedToken{
+		JTI:       claims.JTI,
+		UserID:    claims.UserID,
+		ExpiresAt: claims.ExpiresAt,
+	})
+}
+
+// セッションを失効させる（リフレッシュトークンの系列・発行済みアクセストークン・接続中のソケット）
+func (r *revocationStore) revokeSession(userID uint, sessionID string) error {
+	if err := revokeTokenFamily(db, sessionID); err != nil {
+		return err
+	}
+	// このセッションのアクセストークンは、長くても今から accessTokenTTL 後には期限切れになる
+	if err := r.add(models.RevokedToken{
+		SessionID: sessionID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(accessTokenTTL),
+	}); err != nil {
+		return err
+	}
+	hub.DisconnectSession(userID, sessionID)
+	return nil
+}
+
+// 指定したセッション以外のセッションをすべて失効させる（パスワード変更時など）
+func (r *revocationStore) revokeOtherSessions(userID uint, keepSessionID string) error {
+	var sessionIDs []string
+	if err := db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND id <> ?", userID, keepSessionID).
+		Pluck("id", &sessionIDs).Error; err != nil {
+		return err
+	}
+	for _, sid := range sessionIDs {
+		if err := r.revokeSession(userID, sid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ユーザーの全セッションを失効させる
+func (r *revocationStore) revokeAllSessions(userID uint) error {
+	var sessionIDs []string
+	if err := db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Pluck("id", &sessionIDs).Error; err != nil {
+		return err
+	}
+	for _, sid := range sessionIDs {
+		if err := r.revokeSession(userID, sid); err != nil {
+			return err
+		}
+	}
+	// セッションに属さない接続も含めて切断
+	hub.DisconnectUser(userID)
+	return nil
+}
+
+func (r *revocationStore) add(entry models.RevokedToken) error {
+	if err := db.Create(&entry).Error; err != nil {
+		return err
+	}
+	// 期限切れの記録はついでに掃除する
+	db.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{})
+	return nil
+}
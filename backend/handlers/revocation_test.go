@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"backend/models"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIsRevoked(t *testing.T) {
+	setupTestDB(t)
+	revocations.revokeToken(&AccessClaims{JTI: "revoked-jti", UserID: 1, ExpiresAt: time.Now().Add(time.Minute)})
+	revocations.revokeToken(&AccessClaims{JTI: "expired-entry", UserID: 1, ExpiresAt: time.Now().Add(-time.Minute)})
+	revocations.add(models.RevokedToken{SessionID: "revoked-sid", UserID: 1, ExpiresAt: time.Now().Add(time.Minute)})
+
+	tests := []struct {
+		name   string
+		claims AccessClaims
+		want   bool
+	}{
+		{"nothing to check", AccessClaims{}, false},
+		{"active token", AccessClaims{JTI: "ok", SessionID: "ok-sid"}, false},
+		{"revoked token", AccessClaims{JTI: "revoked-jti", SessionID: "ok-sid"}, true},
+		{"revoked session", AccessClaims{JTI: "ok", SessionID: "revoked-sid"}, true},
+		{"entry past its expiry", AccessClaims{JTI: "expired-entry"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := revocations.isRevoked(&tt.claims); got != tt.want {
+				t.Errorf("isRevoked = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		handler     gin.HandlerFunc
+		wantOtherOK bool // 別の端末のセッションが残るか
+	}{
+		{"this session", "/logout", LogoutHandler, true},
+		{"all sessions", "/logout/all", LogoutAllHandler, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			useTestKeys(t)
+			h := useTestHub(t)
+			alice := createTestUser(t, "alice")
+			laptop := loginTestSession(t, alice.ID, "laptop")
+			phone := loginTestSession(t, alice.ID, "phone")
+
+			// 端末ごとのソケット
+			socket := func(pair tokenPair) *Client {
+				claims, _ := ParseAccessToken(pair.Token)
+				client := &Client{hub: h, userID: alice.ID, sessionID: claims.SessionID, rooms: map[uint]bool{flushRoom: true}, send: make(chan []byte, sendQueueSize)}
+				h.register <- client
+				return client
+			}
+			laptopSocket := socket(laptop)
+			phoneSocket := socket(phone)
+
+			w := serveWithToken(laptop.Token, "POST", tt.path, tt.path, nil, tt.handler)
+			expectStatus(t, w, http.StatusOK)
+
+			// ログアウトしたセッションのトークンは使えない
+			if w := serveWithToken(laptop.Token, "GET", "/me", "/me", nil, MeHandler(testDB)); w.Code != http.StatusUnauthorized {
+				t.Errorf("access token after logout: status = %d, want 401", w.Code)
+			}
+			if w := serveAs(0, "POST", "/auth/refresh", "/auth/refresh", map[string]string{"refresh_token": laptop.RefreshToken}, RefreshHandler(testDB)); w.Code != http.StatusUnauthorized {
+				t.Errorf("refresh token after logout: status = %d, want 401", w.Code)
+			}
+
+			w = serveWithToken(phone.Token, "GET", "/me", "/me", nil, MeHandler(testDB))
+			if (w.Code == http.StatusOK) != tt.wantOtherOK {
+				t.Errorf("other session: status = %d, want ok = %v", w.Code, tt.wantOtherOK)
+			}
+
+			// ログアウトしたセッションのソケットは切断される
+			h.BroadcastToRoom(flushRoom, "flush-logout")
+			if _, open := receiveFrame(t, laptopSocket); open {
+				t.Error("socket of the logged out session is still open")
+			}
+			if _, open := receiveFrame(t, phoneSocket); open != tt.wantOtherOK {
+				t.Errorf("socket of the other session: open = %v, want %v", open, tt.wantOtherOK)
+			}
+		})
+	}
+}
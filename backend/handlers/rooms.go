@@ -67,6 +67,11 @@ func CreateRoomHandler(db *gorm.DB) gin.HandlerFunc { //✅
 		}
 		db.Create(&members)
 
+		// 🔸 接続中のWebSocketを新しいルームに参加させる
+		for _, m := range members {
+			hub.SubscribeUser(m.UserID, room.ID)
+		}
+
 		// 新規作成されたルームIDを返す
 		c.JSON(http.StatusOK, gin.H{"room_id": room.ID})
 	}
@@ -187,6 +192,7 @@ func CreateGrouproomHandler(c *gin.Context) {
 			UserID:   id,
 			JoinedAt: time.Now(),
 		})
+		hub.SubscribeUser(id, room.ID)
 	}
 
 	// レスポンスとしてグループルームの情報を返す
@@ -0,0 +1,277 @@
+// handlers/search.go
+package handlers
+
+import (
+	"backend/models"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 検索語の上限（長すぎる・多すぎる検索語で重いクエリにならないように）
+const (
+	searchMaxQueryLength = 200
+	searchMaxTerms       = 10
+)
+
+// スニペットの長さ（一致箇所の前後に残す文字数）
+const snippetRadius = 40
+
+// 検索結果の1件
+type searchResult struct {
+	models.Message `json:",inline"`
+	Snippet        string `json:"snippet"` // HTML エスケープ済み。一致箇所は <mark>...</mark> で囲む
+}
+
+// 検索結果のレスポンス（新しい順）
+type searchPage struct {
+	Results    []searchResult `json:"results"`
+	HasMore    bool           `json:"has_more"`
+	NextBefore *uint          `json:"next_before"` // 続きを取るときに before に渡す
+}
+
+// 空白で区切らない文字（漢字・ひらがな・カタカナ・ハングル）を含むか
+// 含む語は to_tsvector で単語に分けられないので、pg_trgm の部分一致で探す
+func isSpacelessScript(term string) bool {
+	for _, r := range term {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			return true
+		}
+	}
+	return false
+}
+
+// LIKE のワイルドカードをエスケープする
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// 日付の指定を読む（RFC3339 か YYYY-MM-DD）
+// YYYY-MM-DD の to はその日の終わりまで含める
+func parseSearchTime(v string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// 本文から一致箇所の前後を切り出し、一致箇所を <mark> で囲む
+func buildSnippet(content string, terms []string) string {
+	runes := []rune(content)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// 一致した文字に印を付ける
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		t := []rune(strings.ToLower(term))
+		if len(t) == 0 {
+			continue
+		}
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) != string(t) {
+				continue
+			}
+			for j := i; j < i+len(t); j++ {
+				marked[j] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+
+	// 最初の一致箇所の前後を残す（一致がなければ先頭から）
+	start, end := 0, len(runes)
+	if first < 0 {
+		first = 0
+	}
+	if first > snippetRadius {
+		start = first - snippetRadius
+	}
+	if end-start > snippetRadius*2 {
+		end = start + snippetRadius*2
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	inMark := false
+	for i := start; i < end; i++ {
+		if marked[i] != inMark {
+			if marked[i] {
+				b.WriteString("<mark>")
+			} else {
+				b.WriteString("</mark>")
+			}
+			inMark = marked[i]
+		}
+		b.WriteString(html.EscapeString(string(runes[i])))
+	}
+	if inMark {
+		b.WriteString("</mark>")
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// ==============================
+// 🔹 メッセージ検索ハンドラー
+// ==============================
+// - リクエスト: GET /search/messages?q=会議 資料&room_id=3&sender_id=5&from=2024-01-01&to=2024-01-31&has_attachment=true&before=123&limit=20
+// - 処理:
+//  1. 自分が参加しているルームのメッセージだけを対象にする
+//  2. 英数字の語は content_tsv（全文検索）と部分一致、日本語などを含む語は pg_trgm の部分一致で探す（すべての語を含むものが一致）
+//  3. ルーム・送信者・期間・添付の有無で絞り込む
+//  4. 新しい順に limit 件と、一致箇所を <mark> で囲んだスニペットを返す
+func SearchMessagesHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	if len([]rune(query)) > searchMaxQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is too long"})
+		return
+	}
+	terms := strings.Fields(query)
+	if len(terms) > searchMaxTerms {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many search terms"})
+		return
+	}
+
+	// 参加しているルームのメッセージだけ（退出したルームは対象外）
+	q := db.Model(&models.Message{}).
+		Joins("JOIN room_members ON room_members.room_id = messages.room_id AND room_members.user_id = ? AND room_members.deleted_at IS NULL", userID)
+
+	// 🔸 検索語（すべての語を含むものが一致）
+	var highlights []string
+	for _, term := range terms {
+		if isSpacelessScript(term) {
+			q = q.Where(`messages.content ILIKE ? ESCAPE '\'`, "%"+escapeLike(term)+"%")
+			highlights = append(highlights, term)
+			continue
+		}
+		// 全文検索では記号が無視されるので、英数字の部分だけを使う
+		parts := strings.FieldsFunc(term, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, w := range parts {
+			// 「会議はmeetingです」のように日本語とつながった英単語は単語に分かれないので、部分一致でも探す
+			q = q.Where(`(messages.content_tsv @@ plainto_tsquery('simple', ?) OR messages.content ILIKE ? ESCAPE '\')`, w, "%"+escapeLike(w)+"%")
+		}
+		highlights = append(highlights, parts...)
+	}
+	if len(highlights) == 0 {
+		// 記号だけの検索語では絞り込めない
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q has no searchable terms"})
+		return
+	}
+
+	// 🔸 絞り込み
+	for _, f := range []struct {
+		param  string
+		column string
+	}{
+		{"room_id", "messages.room_id"},
+		{"sender_id", "messages.sender_id"},
+	} {
+		if v := c.Query(f.param); v != "" {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + f.param})
+				return
+			}
+			q = q.Where(f.column+" = ?", id)
+		}
+	}
+	if v := c.Query("from"); v != "" {
+		t, err := parseSearchTime(v, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+		q = q.Where("messages.created_at >= ?", t)
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := parseSearchTime(v, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+		q = q.Where("messages.created_at < ?", t)
+	}
+	if v := c.Query("has_attachment"); v != "" {
+		has, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid has_attachment"})
+			return
+		}
+		exists := "EXISTS (SELECT 1 FROM message_attachments WHERE message_attachments.message_id = messages.id)"
+		if !has {
+			exists = "NOT " + exists
+		}
+		q = q.Where(exists)
+	}
+
+	// 🔸 ページング（新しい順、before より古いもの）
+	limit := messagePageDefault
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = min(n, messagePageMax)
+	}
+	if v := c.Query("before"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before"})
+			return
+		}
+		q = q.Where("(messages.created_at, messages.id) < (SELECT created_at, id FROM messages WHERE id = ?)", id)
+	}
+
+	var messages []models.Message
+	if err := q.Preload("Attachments").
+		Order("messages.created_at DESC, messages.id DESC").
+		Limit(limit + 1).
+		Find(&messages).Error; err != nil {
+		log.Println("❌ メッセージ検索失敗:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search messages"})
+		return
+	}
+
+	page := searchPage{Results: make([]searchResult, 0, len(messages))}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		page.HasMore = true
+		page.NextBefore = &messages[limit-1].ID
+	}
+	for _, m := range messages {
+		page.Results = append(page.Results, searchResult{Message: m, Snippet: buildSnippet(m.Content, highlights)})
+	}
+	c.JSON(http.StatusOK, page)
+}
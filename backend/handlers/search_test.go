@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"backend/models"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsSpacelessScript(t *testing.T) {
+	tests := []struct {
+		term string
+		want bool
+	}{
+		{"meeting", false},
+		{"v1.2", false},
+		{"会議", true},
+		{"ひらがな", true},
+		{"カタカナ", true},
+		{"회의", true},
+		{"会議はmeeting", true},
+	}
+	for _, tt := range tests {
+		if got := isSpacelessScript(tt.term); got != tt.want {
+			t.Errorf("isSpacelessScript(%q) = %v, want %v", tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"abc", "abc"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`C:\path`, `C:\\path`},
+	}
+	for _, tt := range tests {
+		if got := escapeLike(tt.s); got != tt.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchTime(t *testing.T) {
+	day := time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		v        string
+		endOfDay bool
+		want     time.Time
+		wantErr  bool
+	}{
+		{"RFC3339", "2024-01-31T10:00:00Z", false, time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC), false},
+		{"RFC3339 as to", "2024-01-31T10:00:00Z", true, time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC), false},
+		{"date as from", "2024-01-31", false, day, false},
+		{"date as to includes the whole day", "2024-01-31", true, day.AddDate(0, 0, 1), false},
+		{"invalid", "31/01/2024", false, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSearchTime(tt.v, tt.endOfDay)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSnippet(t *testing.T) {
+	long := strings.Repeat("a", 60) + "needle" + strings.Repeat("b", 60)
+	tests := []struct {
+		name    string
+		content string
+		terms   []string
+		want    string
+	}{
+		{"single match", "明日の会議の資料", []string{"会議"}, "明日の<mark>会議</mark>の資料"},
+		{"case insensitive", "Weekly Meeting notes", []string{"meeting"}, "Weekly <mark>Meeting</mark> notes"},
+		{"several terms", "会議の資料を共有", []string{"会議", "資料"}, "<mark>会議</mark>の<mark>資料</mark>を共有"},
+		{"adjacent matches merge", "abab", []string{"ab"}, "<mark>abab</mark>"},
+		{"html is escaped", "<b>meeting</b>", []string{"meeting"}, "&lt;b&gt;<mark>meeting</mark>&lt;/b&gt;"},
+		{"no match", "hello", []string{"bye"}, "hello"},
+		{"long content is trimmed around the match", long,
+			[]string{"needle"}, "…" + strings.Repeat("a", 40) + "<mark>needle</mark>" + strings.Repeat("b", 34) + "…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildSnippet(tt.content, tt.terms); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchMessagesHandler(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	room := createTestRoom(t, alice.ID, bob.ID)
+	otherRoom := createTestRoom(t, alice.ID)
+	bobOnly := createTestRoom(t, bob.ID)
+
+	base := time.Date(2024, 1, 15, 12, 0, 0, 0, time.Local)
+	create := func(roomID, senderID uint, content string, days int) uint {
+		msg := models.Message{RoomID: roomID, SenderID: senderID, Content: content, CreatedAt: base.AddDate(0, 0, days)}
+		if err := testDB.Create(&msg).Error; err != nil {
+			t.Fatal(err)
+		}
+		return msg.ID
+	}
+	deploy := create(room, alice.ID, "deploy finished for v1.2", 0)
+	meeting := create(room, bob.ID, "明日の会議の資料です", 1)
+	mixed := create(otherRoom, alice.ID, "会議はmeetingです", 2)
+	percent := create(otherRoom, alice.ID, "CPU 100% on deploy", 3)
+	create(bobOnly, bob.ID, "secret deploy plan", 4)
+	testDB.Create(&models.MessageAttachment{MessageID: meeting, FileName: "slides.pdf"})
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		wantStatus int
+		wantIDs    []uint // 新しい順
+	}{
+		{"english word", url.Values{"q": {"deploy"}}, http.StatusOK, []uint{percent, deploy}},
+		{"japanese substring", url.Values{"q": {"会議"}}, http.StatusOK, []uint{mixed, meeting}},
+		{"english word inside japanese", url.Values{"q": {"meeting"}}, http.StatusOK, []uint{mixed}},
+		{"all terms must match", url.Values{"q": {"会議 資料"}}, http.StatusOK, []uint{meeting}},
+		{"percent is literal", url.Values{"q": {"100%"}}, http.StatusOK, []uint{percent}},
+		{"room filter", url.Values{"q": {"deploy"}, "room_id": {fmt.Sprint(room)}}, http.StatusOK, []uint{deploy}},
+		{"sender filter", url.Values{"q": {"会議"}, "sender_id": {fmt.Sprint(bob.ID)}}, http.StatusOK, []uint{meeting}},
+		{"date range", url.Values{"q": {"会議"}, "from": {"2024-01-16"}, "to": {"2024-01-16"}}, http.StatusOK, []uint{meeting}},
+		{"with attachment", url.Values{"q": {"会議"}, "has_attachment": {"true"}}, http.StatusOK, []uint{meeting}},
+		{"without attachment", url.Values{"q": {"会議"}, "has_attachment": {"false"}}, http.StatusOK, []uint{mixed}},
+		{"paging", url.Values{"q": {"deploy"}, "before": {fmt.Sprint(percent)}}, http.StatusOK, []uint{deploy}},
+		{"missing q", url.Values{}, http.StatusBadRequest, nil},
+		{"symbols only", url.Values{"q": {"!!!"}}, http.StatusBadRequest, nil},
+		{"too many terms", url.Values{"q": {strings.Repeat("a ", searchMaxTerms+1)}}, http.StatusBadRequest, nil},
+		{"invalid from", url.Values{"q": {"deploy"}, "from": {"yesterday"}}, http.StatusBadRequest, nil},
+		{"invalid has_attachment", url.Values{"q": {"deploy"}, "has_attachment": {"maybe"}}, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(alice.ID, "GET", "/search/messages", "/search/messages?"+tt.query.Encode(), nil, SearchMessagesHandler)
+			expectStatus(t, w, tt.wantStatus)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var page searchPage
+			decodeBody(t, w, &page)
+			ids := []uint{}
+			for _, r := range page.Results {
+				ids = append(ids, r.ID)
+				if !strings.Contains(r.Snippet, "<mark>") {
+					t.Errorf("snippet %q has no highlight", r.Snippet)
+				}
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+
+	t.Run("limit", func(t *testing.T) {
+		w := serveAs(alice.ID, "GET", "/search/messages", "/search/messages?q=deploy&limit=1", nil, SearchMessagesHandler)
+		expectStatus(t, w, http.StatusOK)
+		var page searchPage
+		decodeBody(t, w, &page)
+		if len(page.Results) != 1 || !page.HasMore || page.NextBefore == nil || *page.NextBefore != percent {
+			t.Errorf("page = %+v, want one result and next_before %d", page, percent)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+// handlers/sessions.go
+package handlers
+
+import (
+	"backend/models"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// last_used_at を書き込む最短間隔（リクエストごとに UPDATE しないため）
+const sessionTouchInterval = time.Minute
+
+var (
+	sessionTouchMu sync.Mutex
+	sessionTouched = make(map[string]time.Time)
+)
+
+// ログイン成功時にセッションを作り、トークンの組を発行する
+// device はクライアントが指定した端末名（空なら User-Agent から推定）
+func startSession(c *gin.Context, userID uint, device string) (tokenPair, error) {
+	sid, err := randomToken(16)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	ua := c.Request.UserAgent()
+	if device == "" {
+		device = deviceFromUserAgent(ua)
+	}
+
+	now := time.Now()
+	if err := db.Create(&models.Session{
+		ID:         sid,
+		UserID:     userID,
+		Device:     device,
+		IP:         c.ClientIP(),
+		UserAgent:  ua,
+		LastUsedAt: now,
+	}).Error; err != nil {
+		return tokenPair{}, err
+	}
+
+	return issueTokenPair(db, userID, sid)
+}
+
+// User-Agent からおおまかな端末名を推定
+func deviceFromUserAgent(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone"):
+		return "iPhone"
+	case strings.Contains(ua, "iPad"):
+		return "iPad"
+	case strings.Contains(ua, "Android"):
+		return "Android"
+	case strings.Contains(ua, "Windows"):
+		return "Windows"
+	case strings.Contains(ua, "Macintosh"):
+		return "Mac"
+	case strings.Contains(ua, "Linux"):
+		return "Linux"
+	case ua == "":
+		return "unknown"
+	default:
+		return "other"
+	}
+}
+
+// セッションの最終利用時刻を更新（一定間隔ごと）
+func touchSession(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+
+	now := time.Now()
+	sessionTouchMu.Lock()
+	if now.Sub(sessionTouched[sessionID]) < sessionTouchInterval {
+		sessionTouchMu.Unlock()
+		return
+	}
+	sessionTouched[sessionID] = now
+	sessionTouchMu.Unlock()
+
+	if err := db.Model(&models.Session{}).
+		Where("id = ?", sessionID).
+		UpdateColumn("last_used_at", now).Error; err != nil {
+		log.Println("❌ session touch error:", err)
+	}
+}
+
+// 間隔を過ぎた記録を定期的に消す（main.go から呼び出される）
+// 終了したセッションの記録が残り続けないようにする。リクエストごとの touchSession では走査しない
+func StartSessionTouchSweeper() {
+	ticker := time.NewTicker(sessionTouchInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sweepSessionTouches(time.Now())
+	}
+}
+
+func sweepSessionTouches(now time.Time) {
+	sessionTouchMu.Lock()
+	defer sessionTouchMu.Unlock()
+	for sid, t := range sessionTouched {
+		if now.Sub(t) >= sessionTouchInterval {
+			delete(sessionTouched, sid)
+		}
+	}
+}
+
+// ==============================
+// 🔹 セッション一覧ハンドラー
+// ==============================
+// - リクエスト: GET /me/sessions
+// - 処理: 自分の有効なセッション（端末・IP・User-Agent・最終利用時刻）を返す
+func GetSessionsHandler(c *gin.Context) {
+	claims := GetAccessClaims(c)
+	if claims == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "ユーザーIDが取得できません"})
+		return
+	}
+
+	var sessions []models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL", claims.UserID).
+		Order("last_used_at DESC").
+		Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
+		return
+	}
+
+	type sessionResponse struct {
+		models.Session
+		Current bool `json:"current"` // このリクエストに使ったセッションか
+	}
+	result := []sessionResponse{}
+	for _, s := range sessions {
+		result = append(result, sessionResponse{Session: s, Current: s.ID == claims.SessionID})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ==============================
+// 🔹 セッション終了ハンドラー
+// ==============================
+// - リクエスト: DELETE /me/sessions/:id
+// - 処理: 指定したセッションを失効させ、その端末の WebSocket / SSE を切断する
+func DeleteSessionHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+	sessionID := c.Param("id")
+
+	var session models.Session
+	if err := db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
+		return
+	}
+
+	if err := revocations.revokeSession(userID, session.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "セッションの終了に失敗しました"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
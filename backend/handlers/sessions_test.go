@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"backend/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeviceFromUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)", "iPhone"},
+		{"Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X)", "iPad"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8)", "Android"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64)", "Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0)", "Mac"},
+		{"Mozilla/5.0 (X11; Linux x86_64)", "Linux"},
+		{"", "unknown"},
+		{"curl/8.0", "other"},
+	}
+	for _, tt := range tests {
+		if got := deviceFromUserAgent(tt.ua); got != tt.want {
+			t.Errorf("deviceFromUserAgent(%q) = %q, want %q", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestTouchSession(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	loginTestSession(t, alice.ID, "laptop")
+	var session models.Session
+	testDB.Where("user_id = ?", alice.ID).First(&session)
+
+	old := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name        string
+		lastTouched time.Time // 前回書き込んだ時刻（ゼロなら記録なし）
+		wantWrite   bool
+	}{
+		{"first touch", time.Time{}, true},
+		{"within interval", time.Now(), false},
+		{"interval elapsed", time.Now().Add(-sessionTouchInterval), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDB.Model(&session).UpdateColumn("last_used_at", old)
+			sessionTouchMu.Lock()
+			if tt.lastTouched.IsZero() {
+				delete(sessionTouched, session.ID)
+			} else {
+				sessionTouched[session.ID] = tt.lastTouched
+			}
+			sessionTouchMu.Unlock()
+
+			touchSession(session.ID)
+
+			var got models.Session
+			testDB.First(&got, "id = ?", session.ID)
+			if wrote := got.LastUsedAt.After(old.Add(time.Minute)); wrote != tt.wantWrite {
+				t.Errorf("last_used_at updated = %v, want %v", wrote, tt.wantWrite)
+			}
+		})
+	}
+}
+
+func TestSweepSessionTouches(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		touched   time.Time
+		wantEvict bool
+	}{
+		{"just touched", now, false},
+		{"within interval", now.Add(-sessionTouchInterval / 2), false},
+		{"interval elapsed", now.Add(-sessionTouchInterval), true},
+		{"ended long ago", now.Add(-time.Hour), true},
+	}
+
+	sessionTouchMu.Lock()
+	prev := sessionTouched
+	sessionTouched = make(map[string]time.Time)
+	for _, tt := range tests {
+		sessionTouched[tt.name] = tt.touched
+	}
+	sessionTouchMu.Unlock()
+	t.Cleanup(func() {
+		sessionTouchMu.Lock()
+		sessionTouched = prev
+		sessionTouchMu.Unlock()
+	})
+
+	sweepSessionTouches(now)
+
+	sessionTouchMu.Lock()
+	defer sessionTouchMu.Unlock()
+	for _, tt := range tests {
+		if _, ok := sessionTouched[tt.name]; ok == tt.wantEvict {
+			t.Errorf("%s: kept = %v, want %v", tt.name, ok, !tt.wantEvict)
+		}
+	}
+}
+
+func TestSessionHandlers(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	laptop := loginTestSession(t, alice.ID, "laptop")
+	phone := loginTestSession(t, alice.ID, "phone")
+	bobs := loginTestSession(t, bob.ID, "bob's laptop")
+
+	sessionIDOf := func(pair tokenPair) string {
+		claims, err := ParseAccessToken(pair.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claims.SessionID
+	}
+	phoneID := sessionIDOf(phone)
+	bobsID := sessionIDOf(bobs)
+
+	list := func() map[string]bool {
+		t.Helper()
+		w := serveWithToken(laptop.Token, "GET", "/me/sessions", "/me/sessions", nil, GetSessionsHandler)
+		expectStatus(t, w, http.StatusOK)
+		var sessions []struct {
+			ID      string `json:"id"`
+			Device  string `json:"device"`
+			Current bool   `json:"current"`
+		}
+		decodeBody(t, w, &sessions)
+		got := map[string]bool{} // device → current
+		for _, s := range sessions {
+			got[s.Device] = s.Current
+		}
+		return got
+	}
+
+	if got := list(); len(got) != 2 || !got["laptop"] || got["phone"] {
+		t.Fatalf("sessions = %v, want laptop (current) and phone", got)
+	}
+
+	tests := []struct {
+		name       string
+		sessionID  string
+		wantStatus int
+	}{
+		{"another user's session", bobsID, http.StatusNotFound},
+		{"unknown session", "no-such-session", http.StatusNotFound},
+		{"own session", phoneID, http.StatusNoContent},
+		{"already ended", phoneID, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveWithToken(laptop.Token, "DELETE", "/me/sessions/:id", "/me/sessions/"+tt.sessionID, nil, DeleteSessionHandler)
+			expectStatus(t, w, tt.wantStatus)
+		})
+	}
+
+	if got := list(); len(got) != 1 || !got["laptop"] {
+		t.Errorf("sessions after ending phone = %v, want laptop only", got)
+	}
+	if _, err := ParseAccessToken(phone.Token); err == nil {
+		t.Error("access token of the ended session is still accepted")
+	}
+	if _, err := ParseAccessToken(bobs.Token); err != nil {
+		t.Errorf("bob's session was affected: %v", err)
+	}
+}
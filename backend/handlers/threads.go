@@ -0,0 +1,169 @@
+// handlers/threads.go
+package handlers
+
+import (
+	"backend/models"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errInvalidThreadRoot = errors.New("invalid thread root ID")
+	errThreadOtherRoom   = errors.New("thread message must belong to the same room")
+	errNestedThread      = errors.New("cannot reply to a thread reply")
+)
+
+// スレッドの親メッセージとして使えるか確認する
+// 同じルームの、それ自体が返信ではないメッセージに限る（スレッドは1階層だけ）
+func validateThreadRoot(roomID, rootID uint) error {
+	var root models.Message
+	if err := db.Select("id", "room_id", "thread_root_id").First(&root, rootID).Error; err != nil {
+		return errInvalidThreadRoot
+	}
+	if root.RoomID != roomID {
+		return errThreadOtherRoom
+	}
+	if root.ThreadRootID != nil {
+		return errNestedThread
+	}
+	return nil
+}
+
+// 親メッセージに返信数・最後の返信時刻・返信したユーザーを付ける
+func fillThreadSummaries(messages []models.MessageWithRead) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+
+	var counts []struct {
+		ThreadRootID uint
+		ReplyCount   int64
+		LastReplyAt  time.Time
+	}
+	if err := db.Model(&models.Message{}).
+		Select("thread_root_id, COUNT(*) AS reply_count, MAX(created_at) AS last_reply_at").
+		Where("thread_root_id IN ?", ids).
+		Group("thread_root_id").
+		Scan(&counts).Error; err != nil {
+		return err
+	}
+
+	var participants []struct {
+		ThreadRootID uint
+		SenderID     uint
+	}
+	if err := db.Model(&models.Message{}).
+		Select("thread_root_id, sender_id").
+		Where("thread_root_id IN ?", ids).
+		Group("thread_root_id, sender_id").
+		Order("MIN(created_at), sender_id").
+		Scan(&participants).Error; err != nil {
+		return err
+	}
+
+	index := make(map[uint]int, len(messages))
+	for i := range messages {
+		index[messages[i].ID] = i
+		messages[i].ParticipantIDs = []uint{}
+	}
+	for _, c := range counts {
+		m := &messages[index[c.ThreadRootID]]
+		m.ReplyCount = c.ReplyCount
+		lastReplyAt := c.LastReplyAt
+		m.LastReplyAt = &lastReplyAt
+	}
+	for _, p := range participants {
+		m := &messages[index[p.ThreadRootID]]
+		m.ParticipantIDs = append(m.ParticipantIDs, p.SenderID)
+	}
+	return nil
+}
+
+// スレッドのレスポンス（返信は古い順）
+type threadPage struct {
+	Root models.MessageWithRead `json:"root"`
+	messagePage
+}
+
+// ==============================
+// 🔹 スレッド取得ハンドラー
+// ==============================
+// - リクエスト: GET /messages/:id/thread?before=123&limit=50（after / around も使える）
+// - 処理:
+//  1. 親メッセージを取得（返信の ID が指定されたらその親のスレッドを返す）
+//  2. 親メッセージのルームのメンバーか確認
+//  3. 返信をカーソルに従って1ページ分返す（親メッセージには返信数などを付ける）
+func GetThreadHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+		return
+	}
+
+	var root models.Message
+	if err := db.Preload("Attachments").First(&root, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	if root.ThreadRootID != nil {
+		// 主キーが残っていると条件に加わるので、空の構造体に読み直す
+		rootID := *root.ThreadRootID
+		root = models.Message{}
+		if err := db.Preload("Attachments").First(&root, rootID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+	}
+
+	if !isRoomMember(db, root.RoomID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+		return
+	}
+
+	cur, err := parseMessageCursor(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := loadMessagePage(threadReplies(root.ID), cur)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "cursor message not found"})
+		return
+	}
+	roots := toMessagesWithRead([]models.Message{root})
+	if err == nil {
+		err = fillReadFlags(page.Messages, userID)
+	}
+	if err == nil {
+		err = fillReadFlags(roots, userID)
+	}
+	if err == nil {
+		err = fillThreadSummaries(roots)
+	}
+	if err == nil {
+		err = fillReactions(page.Messages, userID)
+	}
+	if err == nil {
+		err = fillReactions(roots, userID)
+	}
+	if err != nil {
+		log.Println("❌ スレッド取得失敗:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get thread"})
+		return
+	}
+
+	c.JSON(http.StatusOK, threadPage{Root: roots[0], messagePage: *page})
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"backend/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestToWSMessage(t *testing.T) {
+	rootID := uint(1)
+	created := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		msg      models.Message
+		atts     []models.MessageAttachment
+		wantType string
+		wantRoot *uint
+		wantAtts int
+	}{
+		{"room message", models.Message{ID: 1, RoomID: 3, Content: "hi", CreatedAt: created}, nil, "message", nil, 0},
+		{"thread reply", models.Message{ID: 2, RoomID: 3, Content: "re", ThreadRootID: &rootID, CreatedAt: created}, nil, "thread_reply", &rootID, 0},
+		{"with attachments", models.Message{ID: 3, RoomID: 3, CreatedAt: created},
+			[]models.MessageAttachment{{FileName: "a.png"}, {FileName: "b.pdf"}}, "message", nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := toWSMessage(tt.msg, tt.atts)
+			if got.Type != tt.wantType || !reflect.DeepEqual(got.ThreadRootID, tt.wantRoot) || len(got.Attachments) != tt.wantAtts {
+				t.Errorf("got %+v", got)
+			}
+			if got.ID != tt.msg.ID || got.RoomID != tt.msg.RoomID || got.CreatedAt != "2024-01-15T12:00:00Z" {
+				t.Errorf("got %+v", got)
+			}
+		})
+	}
+}
+
+func TestValidateThreadRoot(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	room := createTestRoom(t, alice.ID)
+	otherRoom := createTestRoom(t, alice.ID)
+	root := createTestMessage(t, room, alice.ID, "root")
+	reply := models.Message{RoomID: room, SenderID: alice.ID, Content: "reply", ThreadRootID: &root.ID, CreatedAt: time.Now()}
+	testDB.Create(&reply)
+
+	tests := []struct {
+		name   string
+		roomID uint
+		rootID uint
+		want   error
+	}{
+		{"root message", room, root.ID, nil},
+		{"unknown message", room, 99999, errInvalidThreadRoot},
+		{"message in another room", otherRoom, root.ID, errThreadOtherRoom},
+		{"reply to a reply", room, reply.ID, errNestedThread},
+	}
+	for _, tt := range tests {
+		if got := validateThreadRoot(tt.roomID, tt.rootID); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestThreadReplies(t *testing.T) {
+	setupTestDB(t)
+	h := useTestHub(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	room := createTestRoom(t, alice.ID, bob.ID)
+	root := createTestMessage(t, room, alice.ID, "root")
+	peer := connectTestClient(h, bob.ID, room)
+
+	// 返信の送信
+	send := func(userID uint, body map[string]interface{}) int {
+		return serveAs(userID, "POST", "/messages/group", "/messages/group", body, SendGroupMessageHandler).Code
+	}
+	sendTests := []struct {
+		name       string
+		userID     uint
+		body       map[string]interface{}
+		wantStatus int
+	}{
+		{"room_id is required", alice.ID, map[string]interface{}{"content": "re", "thread_root_id": root.ID}, http.StatusBadRequest},
+		{"not a member", carol.ID, map[string]interface{}{"room_id": room, "content": "re", "thread_root_id": root.ID}, http.StatusForbidden},
+		{"unknown root", alice.ID, map[string]interface{}{"room_id": room, "content": "re", "thread_root_id": 99999}, http.StatusBadRequest},
+		{"reply by bob", bob.ID, map[string]interface{}{"room_id": room, "content": "re 1", "thread_root_id": root.ID}, http.StatusOK},
+		{"reply by alice", alice.ID, map[string]interface{}{"room_id": room, "content": "re 2", "thread_root_id": root.ID}, http.StatusOK},
+	}
+	for _, tt := range sendTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := send(tt.userID, tt.body); got != tt.wantStatus {
+				t.Errorf("status = %d, want %d", got, tt.wantStatus)
+			}
+		})
+	}
+
+	// 返信はルームに thread_reply として届く
+	for i := 0; i < 2; i++ {
+		data, _ := receiveFrame(t, peer)
+		var ev struct {
+			Type         string `json:"type"`
+			ThreadRootID *uint  `json:"thread_root_id"`
+		}
+		json.Unmarshal([]byte(data), &ev)
+		if ev.Type != "thread_reply" || ev.ThreadRootID == nil || *ev.ThreadRootID != root.ID {
+			t.Errorf("frame = %s, want thread_reply to %d", data, root.ID)
+		}
+	}
+
+	// スレッドの取得
+	var replies []models.Message
+	testDB.Where("thread_root_id = ?", root.ID).Order("id").Find(&replies)
+	if len(replies) != 2 {
+		t.Fatalf("replies = %d, want 2", len(replies))
+	}
+	getTests := []struct {
+		name        string
+		userID      uint
+		id          uint
+		wantStatus  int
+		wantReplies int
+	}{
+		{"by root", alice.ID, root.ID, http.StatusOK, 2},
+		{"by a reply", bob.ID, replies[0].ID, http.StatusOK, 2},
+		{"not a member", carol.ID, root.ID, http.StatusForbidden, 0},
+		{"unknown message", alice.ID, 99999, http.StatusNotFound, 0},
+	}
+	for _, tt := range getTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(tt.userID, "GET", "/messages/:id/thread", fmt.Sprintf("/messages/%d/thread", tt.id), nil, GetThreadHandler)
+			expectStatus(t, w, tt.wantStatus)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var page struct {
+				Root struct {
+					ID             uint   `json:"id"`
+					ReplyCount     int64  `json:"reply_count"`
+					ParticipantIDs []uint `json:"participant_ids"`
+				} `json:"root"`
+				Messages []models.Message `json:"messages"`
+			}
+			decodeBody(t, w, &page)
+			if page.Root.ID != root.ID || page.Root.ReplyCount != 2 || !reflect.DeepEqual(page.Root.ParticipantIDs, []uint{bob.ID, alice.ID}) {
+				t.Errorf("root = %+v", page.Root)
+			}
+			if len(page.Messages) != tt.wantReplies || page.Messages[0].ID != replies[0].ID {
+				t.Errorf("replies = %+v", page.Messages)
+			}
+		})
+	}
+}
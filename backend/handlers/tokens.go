@@ -0,0 +1,235 @@
+// handlers/tokens.go
+package handlers
+
+import (
+	"backend/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// トークンの有効期限（環境変数で変更可能）
+var (
+	accessTokenTTL  = envDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	refreshTokenTTL = envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+)
+
+var (
+	errRefreshTokenInvalid = errors.New("invalid refresh token")
+	errPurposeTokenInvalid = errors.New("invalid or expired token")
+)
+
+// ログイン・リフレッシュ時のレスポンス
+type tokenPair struct {
+	Token        string `json:"token"` // アクセストークン（JWT）
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // アクセストークンの残り秒数
+}
+
+// 推測できないランダム文字列（URL セーフな base64）
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DB に保存する用のハッシュ
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 短命のアクセストークン（JWT）を生成
+// jti はトークンごと、sid はログイン（リフレッシュトークンの系列）ごとのID
+func generateAccessToken(userID uint, sessionID string) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return jwtKeys.sign(jwt.MapClaims{
+		"user_id": userID,
+		"jti":     jti,
+		"sid":     sessionID,
+		"iat":     now.Unix(),
+		"exp":     now.Add(accessTokenTTL).Unix(),
+	})
+}
+
+// 用途を限定した短命の JWT（2FA のチャレンジ、メール確認、パスワード再設定）
+// typ クレームを持つので、アクセストークンとしては使えない（ParseAccessToken が拒否する）
+func issuePurposeToken(typ string, userID uint, ttl time.Duration, extra jwt.MapClaims) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"typ":     typ,
+		"user_id": userID,
+		"jti":     jti,
+		"iat":     now.Unix(),
+		"exp":     now.Add(ttl).Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return jwtKeys.sign(claims)
+}
+
+// issuePurposeToken で発行したトークンを検証する（typ が違うもの・失効済みのものは拒否）
+func parsePurposeToken(typ, tokenString string) (*AccessClaims, jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, jwtKeys.keyFunc)
+	if err != nil || !token.Valid {
+		return nil, nil, errPurposeTokenInvalid
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != typ {
+		return nil, nil, errPurposeTokenInvalid
+	}
+	userIDFloat, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, nil, errPurposeTokenInvalid
+	}
+
+	result := &AccessClaims{UserID: uint(userIDFloat)}
+	result.JTI, _ = claims["jti"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		result.ExpiresAt = exp.Time
+	}
+	// 使用済みのトークンは revokeToken で失効させてある
+	if result.JTI == "" || revocations.isRevoked(result) {
+		return nil, nil, errPurposeTokenInvalid
+	}
+	return result, claims, nil
+}
+
+// アクセストークンとリフレッシュトークンを発行する
+// familyID はセッションID（新しいログインでは startSession から呼ぶ）
+func issueTokenPair(db *gorm.DB, userID uint, familyID string) (tokenPair, error) {
+	refresh, err := randomToken(32)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	if err := db.Create(&models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(refresh),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}).Error; err != nil {
+		return tokenPair{}, err
+	}
+
+	access, err := generateAccessToken(userID, familyID)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	return tokenPair{
+		Token:        access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// 系列のリフレッシュトークンをすべて失効させ、セッションも終了扱いにする
+func revokeTokenFamily(db *gorm.DB, familyID string) error {
+	now := time.Now()
+	if err := db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error
+}
+
+// ==============================
+// 🔹 トークン更新ハンドラー
+// ==============================
+// - リクエスト: POST /auth/refresh { "refresh_token": "..." }
+// - 処理:
+//  1. リフレッシュトークンを検証（使用済み・失効・期限切れは拒否）
+//  2. 使用済みのトークンが再び使われたら漏えいとみなして系列ごと失効（アクセストークン・ソケットも）
+//  3. 使ったトークンを使用済みにして、新しいトークンの組を返す（ローテーション）
+func RefreshHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil || input.RefreshToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		var pair tokenPair
+		var reused *models.RefreshToken // 再利用を検知したトークン（コミット後にセッションを失効させる）
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// 同じトークンでの同時リクエストに備えて行ロック
+			var rt models.RefreshToken
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("token_hash = ?", hashToken(input.RefreshToken)).
+				First(&rt).Error; err != nil {
+				return errRefreshTokenInvalid
+			}
+
+			if rt.UsedAt != nil || rt.RevokedAt != nil {
+				// 再利用（または失効済み）→ 系列ごと失効させてコミットする
+				if rt.RevokedAt == nil {
+					log.Printf("🚨 Refresh token reuse detected: user %d, family %s\n", rt.UserID, rt.FamilyID)
+					reused = &rt
+				}
+				return revokeTokenFamily(tx, rt.FamilyID)
+			}
+			if time.Now().After(rt.ExpiresAt) {
+				return errRefreshTokenInvalid
+			}
+
+			now := time.Now()
+			if err := tx.Model(&rt).Update("used_at", now).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Session{}).
+				Where("id = ?", rt.FamilyID).
+				UpdateColumn("last_used_at", now).Error; err != nil {
+				return err
+			}
+
+			var err error
+			pair, err = issueTokenPair(tx, rt.UserID, rt.FamilyID)
+			return err
+		})
+
+		// 発行済みのアクセストークンと接続中のソケットも止める
+		// （トークン行のロックを持ったままだと待ち合うので、トランザクションの外で行う）
+		if err == nil && reused != nil {
+			if err := revocations.revokeSession(reused.UserID, reused.FamilyID); err != nil {
+				log.Println("❌ session revoke error:", err)
+			}
+		}
+
+		switch {
+		case errors.Is(err, errRefreshTokenInvalid), err == nil && pair.Token == "":
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		case err != nil:
+			log.Println("❌ token refresh error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "トークン更新に失敗しました"})
+		default:
+			c.JSON(http.StatusOK, pair)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"backend/models"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRandomToken(t *testing.T) {
+	tests := []struct {
+		bytes   int
+		wantLen int // base64（パディングなし）の文字数
+	}{
+		{16, 22},
+		{32, 43},
+	}
+	for _, tt := range tests {
+		a, err := randomToken(tt.bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := randomToken(tt.bytes)
+		if len(a) != tt.wantLen {
+			t.Errorf("randomToken(%d) length = %d, want %d", tt.bytes, len(a), tt.wantLen)
+		}
+		if a == b {
+			t.Errorf("randomToken(%d) returned the same value twice", tt.bytes)
+		}
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	// SHA-256 の既知の値
+	if got := hashToken("abc"); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("hashToken = %s", got)
+	}
+	if hashToken("a") == hashToken("b") {
+		t.Error("different tokens have the same hash")
+	}
+}
+
+func TestParseAccessToken(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+
+	valid, _ := generateAccessToken(1, "sid-1")
+	expired, _ := jwtKeys.sign(jwt.MapClaims{"user_id": 1, "jti": "j1", "exp": time.Now().Add(-time.Minute).Unix()})
+	challenge, _ := issuePurposeToken("2fa", 1, time.Minute, nil)
+	otherKey, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": 1, "jti": "j2", "exp": time.Now().Add(time.Minute).Unix()}).
+		SignedString([]byte("other-secret"))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", valid, false},
+		{"with Bearer prefix", "Bearer " + valid, false},
+		{"expired", expired, true},
+		{"signed with another key", otherKey, true},
+		{"purpose token", challenge, true},
+		{"garbage", "not-a-jwt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ParseAccessToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (claims.UserID != 1 || claims.SessionID != "sid-1" || claims.JTI == "") {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestRefreshHandler(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	alice := createTestUser(t, "alice")
+
+	refresh := func(token string) (int, tokenPair) {
+		w := serveAs(0, "POST", "/auth/refresh", "/auth/refresh", map[string]string{"refresh_token": token}, RefreshHandler(testDB))
+		var pair tokenPair
+		if w.Code == http.StatusOK {
+			decodeBody(t, w, &pair)
+		}
+		return w.Code, pair
+	}
+
+	t.Run("input", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			token      string
+			wantStatus int
+		}{
+			{"missing", "", http.StatusBadRequest},
+			{"unknown", "no-such-token", http.StatusUnauthorized},
+		}
+		for _, tt := range tests {
+			if status, _ := refresh(tt.token); status != tt.wantStatus {
+				t.Errorf("%s: status = %d, want %d", tt.name, status, tt.wantStatus)
+			}
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		pair := loginTestSession(t, alice.ID, "test")
+		testDB.Model(&models.RefreshToken{}).Where("token_hash = ?", hashToken(pair.RefreshToken)).
+			UpdateColumn("expires_at", time.Now().Add(-time.Minute))
+		if status, _ := refresh(pair.RefreshToken); status != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", status)
+		}
+	})
+
+	t.Run("rotation and reuse", func(t *testing.T) {
+		login := loginTestSession(t, alice.ID, "test")
+
+		status, rotated := refresh(login.RefreshToken)
+		if status != http.StatusOK || rotated.RefreshToken == login.RefreshToken || rotated.Token == "" {
+			t.Fatalf("refresh = %d %+v, want a new pair", status, rotated)
+		}
+		if claims, err := ParseAccessToken(rotated.Token); err != nil || claims.UserID != alice.ID {
+			t.Fatalf("rotated access token: %+v, %v", claims, err)
+		}
+
+		// 使用済みのトークンが再び使われたら、系列ごと失効する
+		tests := []struct {
+			name  string
+			token string
+		}{
+			{"reusing the old token", login.RefreshToken},
+			{"the rotated token after reuse", rotated.RefreshToken},
+		}
+		for _, tt := range tests {
+			if status, _ := refresh(tt.token); status != http.StatusUnauthorized {
+				t.Errorf("%s: status = %d, want 401", tt.name, status)
+			}
+		}
+		if _, err := ParseAccessToken(rotated.Token); err == nil {
+			t.Error("access token of the reused family is still accepted")
+		}
+	})
+}
@@ -0,0 +1,347 @@
+// handlers/twofactor.go
+package handlers
+
+import (
+	"backend/models"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer = "chat_app"
+	totpPeriod = 30 // 秒
+	totpDigits = 6
+	totpSkew   = 1 // 前後何ステップのずれまで許すか（端末の時計のずれ対策）
+
+	challengeTokenType = "2fa_challenge"
+	challengeTokenTTL  = 5 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ==============================
+// 🔹 TOTP（RFC 6238）
+// ==============================
+
+// 新しい共有鍵（160bit、base32）
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// 時間ステップ step のコード（HOTP, RFC 4226）
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits)))
+}
+
+// コードが合っていれば、その時間ステップを返す
+// 既に使われたステップ（lastStep 以前）のコードは受け付けない
+func matchTOTP(secret, code string, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	now := time.Now().Unix() / totpPeriod
+	for d := -totpSkew; d <= totpSkew; d++ {
+		step := now + int64(d)
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTP コードを検証し、使ったステップを記録する（同じコードの再利用を防ぐ）
+func consumeTOTP(user *models.User, code string) bool {
+	step, ok := matchTOTP(user.TOTPSecret, strings.TrimSpace(code), user.TOTPLastStep)
+	if !ok {
+		return false
+	}
+	// 同時に同じコードが送られても、先に記録した方だけを通す
+	res := db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		UpdateColumn("totp_last_step", step)
+	if res.Error != nil {
+		log.Println("❌ totp step update error:", res.Error)
+		return false
+	}
+	return res.RowsAffected == 1
+}
+
+// 認証アプリに登録するための otpauth:// URL（QRコードにして表示する）
+func totpURL(username, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// ==============================
+// 🔹 リカバリーコード
+// ==============================
+
+// 入力ゆれ（小文字・ハイフン・空白）を吸収する
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// 新しいリカバリーコードを発行し、古いものは無効にする（平文を返すのはこのときだけ）
+func issueRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := totpEncoding.EncodeToString(b)[:10]
+		if err := tx.Create(&models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: hashToken(raw),
+		}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, nil
+}
+
+// 未使用のリカバリーコードなら使用済みにして true
+func consumeRecoveryCode(userID uint, code string) bool {
+	res := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		log.Println("❌ recovery code update error:", res.Error)
+		return false
+	}
+	return res.RowsAffected == 1
+}
+
+// ==============================
+// 🔹 ログインのチャレンジトークン
+// ==============================
+// パスワード確認済み・2FA 未確認の状態を表す短命の JWT
+
+func issueChallengeToken(userID uint, device string) (string, error) {
+	return issuePurposeToken(challengeTokenType, userID, challengeTokenTTL, jwt.MapClaims{"device": device})
+}
+
+// チャレンジトークンを検証する（戻り値の device はログイン時に指定された端末名）
+func parseChallengeToken(tokenString string) (*AccessClaims, string, error) {
+	result, claims, err := parsePurposeToken(challengeTokenType, tokenString)
+	if err != nil {
+		return nil, "", err
+	}
+	device, _ := claims["device"].(string)
+	return result, device, nil
+}
+
+// ==============================
+// 🔹 2FA 設定開始ハンドラー
+// ==============================
+// - リクエスト: POST /me/2fa/setup
+// - 処理:
+//  1. 新しい共有鍵を作り、ユーザーに保存（まだ有効にはしない）
+//  2. 認証アプリ登録用の secret と otpauth_url を返す
+func SetupTwoFactorHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "二要素認証は既に有効です"})
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "鍵の生成に失敗しました"})
+		return
+	}
+	if err := db.Model(&user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "二要素認証の設定に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_url": totpURL(user.Username, secret),
+	})
+}
+
+// ==============================
+// 🔹 2FA 有効化ハンドラー
+// ==============================
+// - リクエスト: POST /me/2fa/verify { "code": "123456" }
+// - 処理:
+//  1. 認証アプリに表示されたコードで、登録できていることを確認
+//  2. 二要素認証を有効にし、リカバリーコードを返す（表示はこの1回だけ）
+func VerifyTwoFactorHandler(c *gin.Context) {
+	userID := GetCurrentUserID(c)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "二要素認証は既に有効です"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "先に /me/2fa/setup を呼び出してください"})
+		return
+	}
+	if !consumeTOTP(&user, input.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "コードが正しくありません"})
+		return
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if codes, err = issueRecoveryCodes(tx, user.ID); err != nil {
+			return err
+		}
+		return tx.Model(&user).Update("totp_enabled", true).Error
+	})
+	if err != nil {
+		log.Println("❌ 2fa enable error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "二要素認証の有効化に失敗しました"})
+		return
+	}
+
+	log.Printf("🔐 2FA enabled: user %d\n", user.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "二要素認証を有効にしました",
+		"recovery_codes": codes,
+	})
+}
+
+// ==============================
+// 🔹 2FA ログインハンドラー（ログインの2段階目）
+// ==============================
+// - リクエスト: POST /login/2fa { "challenge_token": "...", "code": "123456" }
+// - 認証アプリが使えないときは code の代わりに "recovery_code" を送る
+// - 処理:
+//  1. /login が返したチャレンジトークンを検証
+//  2. 失敗が続いているアカウント・IP なら拒否（429。/login と同じ回数で数える）
+//  3. TOTP コードまたは未使用のリカバリーコードを確認。失敗はアカウントの失敗回数に加える
+//  4. チャレンジを使用済みにし、失敗回数をリセットして /login と同じ形でトークンを返す
+func LoginTwoFactorHandler(c *gin.Context) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.ChallengeToken == "" ||
+		(input.Code == "" && input.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	claims, device, err := parseChallengeToken(input.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "チャレンジトークンが無効です。もう一度ログインしてください"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, claims.UserID).Error; err != nil || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "チャレンジトークンが無効です。もう一度ログインしてください"})
+		return
+	}
+
+	// チャレンジトークンを取り直してもコードを総当たりできないよう、ユーザー単位で数える
+	ip := c.ClientIP()
+	if wait := loginGuards.blocked(accountKey(user.Username), ipKey(ip)); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "ログインの試行回数が多すぎます。しばらくしてから再度お試しください"})
+		return
+	}
+
+	var ok bool
+	if input.Code != "" {
+		ok = consumeTOTP(&user, input.Code)
+	} else {
+		ok = consumeRecoveryCode(user.ID, input.RecoveryCode)
+		if ok {
+			log.Printf("🔑 Recovery code used: user %d\n", user.ID)
+		}
+	}
+	if !ok {
+		loginGuards.recordFailure(user.Username, ip, &user.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "コードが正しくありません"})
+		return
+	}
+
+	// 同じチャレンジで2回ログインできないようにする
+	if err := revocations.revokeToken(claims); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ログインに失敗しました"})
+		return
+	}
+	loginGuards.recordSuccess(user.Username)
+
+	pair, err := startSession(c, user.ID, device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークン生成に失敗しました"})
+		return
+	}
+	respondWithTokens(c, pair)
+}
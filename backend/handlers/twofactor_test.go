@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"backend/models"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 付録 B のテストベクター（SHA1、下6桁）
+func TestTOTPCode(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	now := time.Now().Unix() / totpPeriod
+	codeAt := func(d int64) string { return totpCode(key, now+d) }
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastStep int64
+		wantOK   bool
+	}{
+		{"current step", secret, codeAt(0), 0, true},
+		{"previous step (clock skew)", secret, codeAt(-1), 0, true},
+		{"next step (clock skew)", secret, codeAt(1), 0, true},
+		{"too old", secret, codeAt(-3), 0, false},
+		{"already used step", secret, codeAt(0), now, false},
+		{"wrong length", secret, "12345", 0, false},
+		{"invalid secret", "not base32!", codeAt(0), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := matchTOTP(tt.secret, tt.code, tt.lastStep); ok != tt.wantOK {
+				t.Errorf("matchTOTP = %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"ABCDE-FGHIJ", "ABCDEFGHIJ"},
+		{"abcde-fghij", "ABCDEFGHIJ"},
+		{" abcde fghij ", "ABCDEFGHIJ"},
+		{"ABCDEFGHIJ", "ABCDEFGHIJ"},
+	}
+	for _, tt := range tests {
+		if got := normalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPURL(t *testing.T) {
+	u, err := url.Parse(totpURL("alice smith", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/chat_app:alice smith" {
+		t.Errorf("url = %s", u)
+	}
+	want := map[string]string{"secret": "JBSWY3DPEHPK3PXP", "issuer": "chat_app", "algorithm": "SHA1", "digits": "6", "period": "30"}
+	for k, v := range want {
+		if got := u.Query().Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestTwoFactorFlow(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	alice := createTestUser(t, "alice")
+
+	// 登録：鍵を発行し、認証アプリのコードで有効化する
+	w := serveAs(alice.ID, "POST", "/me/2fa/setup", "/me/2fa/setup", nil, SetupTwoFactorHandler)
+	expectStatus(t, w, http.StatusOK)
+	var setup struct {
+		Secret string `json:"secret"`
+	}
+	decodeBody(t, w, &setup)
+	key, _ := totpEncoding.DecodeString(setup.Secret)
+	now := time.Now().Unix() / totpPeriod
+
+	w = serveAs(alice.ID, "POST", "/me/2fa/verify", "/me/2fa/verify", map[string]string{"code": totpCode(key, now-5)}, VerifyTwoFactorHandler)
+	expectStatus(t, w, http.StatusBadRequest)
+	w = serveAs(alice.ID, "POST", "/me/2fa/verify", "/me/2fa/verify", map[string]string{"code": totpCode(key, now-1)}, VerifyTwoFactorHandler)
+	expectStatus(t, w, http.StatusOK)
+	var verified struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decodeBody(t, w, &verified)
+	if len(verified.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("recovery codes = %v", verified.RecoveryCodes)
+	}
+
+	// ログインの2段階目
+	login := func(challenge string, body map[string]string) int {
+		body["challenge_token"] = challenge
+		return serveAs(0, "POST", "/login/2fa", "/login/2fa", body, LoginTwoFactorHandler).Code
+	}
+	challenge := func() string {
+		token, err := issueChallengeToken(alice.ID, "laptop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	used := challenge()
+	tests := []struct {
+		name       string
+		challenge  string
+		body       map[string]string
+		wantStatus int
+	}{
+		{"missing code", challenge(), map[string]string{}, http.StatusBadRequest},
+		{"invalid challenge", "xxx", map[string]string{"code": totpCode(key, now)}, http.StatusUnauthorized},
+		{"code already used for setup", challenge(), map[string]string{"code": totpCode(key, now-1)}, http.StatusUnauthorized},
+		{"current code", used, map[string]string{"code": totpCode(key, now)}, http.StatusOK},
+		{"same challenge again", used, map[string]string{"code": totpCode(key, now+1)}, http.StatusUnauthorized},
+		{"same code again", challenge(), map[string]string{"code": totpCode(key, now)}, http.StatusUnauthorized},
+		{"recovery code", challenge(), map[string]string{"recovery_code": verified.RecoveryCodes[0]}, http.StatusOK},
+		{"recovery code in lower case", challenge(), map[string]string{"recovery_code": strings.ToLower(verified.RecoveryCodes[1])}, http.StatusOK},
+		{"used recovery code", challenge(), map[string]string{"recovery_code": verified.RecoveryCodes[0]}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := login(tt.challenge, tt.body); got != tt.wantStatus {
+				t.Errorf("status = %d, want %d", got, tt.wantStatus)
+			}
+		})
+	}
+
+	// 2FA のコードの失敗もアカウントの失敗回数に数える
+	var throttle models.LoginThrottle
+	if err := testDB.Where("key = ?", accountKey("alice")).First(&throttle).Error; err != nil || throttle.Failures == 0 {
+		t.Errorf("failed codes were not counted: %+v, %v", throttle, err)
+	}
+}
@@ -0,0 +1,96 @@
+// handlers/typing.go
+package handlers
+
+import (
+	"backend/models"
+	"sync"
+	"time"
+)
+
+const (
+	// typing フレームが途絶えてから「入力終了」とみなすまでの時間
+	typingTimeout = 5 * time.Second
+	// 同じユーザー・同じルームの typing を配信する最短間隔
+	typingThrottle = 2 * time.Second
+)
+
+type typingKey struct {
+	roomID uint
+	userID uint
+}
+
+type typingState struct {
+	timer    *time.Timer
+	lastSeen time.Time // 最後に typing フレームを受け取った時刻
+	lastSent time.Time // 最後に他のメンバーへ配信した時刻
+}
+
+// 入力中のユーザーを管理する（DBには保存しない）
+type typingTracker struct {
+	mu     sync.Mutex
+	states map[typingKey]*typingState
+}
+
+var typing = &typingTracker{
+	states: make(map[typingKey]*typingState),
+}
+
+// 入力中のまま配信間隔内であれば、期限だけ延長して true を返す
+func (t *typingTracker) extend(roomID, userID uint) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.states[typingKey{roomID, userID}]
+	if !ok || time.Since(st.lastSent) >= typingThrottle {
+		return false
+	}
+	st.lastSeen = time.Now()
+	st.timer.Reset(typingTimeout)
+	return true
+}
+
+// 入力中を記録して他のメンバーに配信する（期限切れで入力終了を配信）
+func (t *typingTracker) start(roomID, userID uint, senderName string) {
+	key := typingKey{roomID, userID}
+	now := time.Now()
+
+	t.mu.Lock()
+	if st, ok := t.states[key]; ok {
+		st.lastSeen, st.lastSent = now, now
+		st.timer.Reset(typingTimeout)
+	} else {
+		t.states[key] = &typingState{
+			timer:    time.AfterFunc(typingTimeout, func() { t.expire(key) }),
+			lastSeen: now,
+			lastSent: now,
+		}
+	}
+	t.mu.Unlock()
+
+	hub.BroadcastToRoomExcept(roomID, userID, models.WSMessage{
+		Type:       "typing",
+		RoomID:     roomID,
+		SenderID:   userID,
+		SenderName: senderName,
+		CreatedAt:  now.Format(time.RFC3339),
+	})
+}
+
+func (t *typingTracker) expire(key typingKey) {
+	t.mu.Lock()
+	st, ok := t.states[key]
+	// Reset と発火が行き違った場合は、延長後のタイマーに任せる
+	if !ok || time.Since(st.lastSeen) < typingTimeout {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.states, key)
+	t.mu.Unlock()
+
+	hub.BroadcastToRoomExcept(key.roomID, key.userID, models.WSMessage{
+		Type:      "typing_stop",
+		RoomID:    key.roomID,
+		SenderID:  key.userID,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// 共有のハブをテスト用のハブに差し替える（typing・presence などはグローバルの hub に送る）
+func useTestHub(t *testing.T) *Hub {
+	t.Helper()
+	prev := hub
+	hub = startTestHub(t)
+	t.Cleanup(func() { hub = prev })
+	return hub
+}
+
+// タイマーが発火しない状態（テストから expire を直接呼ぶ）
+func newTypingState(lastSeen, lastSent time.Time) *typingState {
+	timer := time.AfterFunc(time.Hour, func() {})
+	timer.Stop()
+	return &typingState{timer: timer, lastSeen: lastSeen, lastSent: lastSent}
+}
+
+func TestTypingExtend(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		state *typingState // nil なら入力中でない
+		want  bool
+	}{
+		{"not typing", nil, false},
+		{"sent within throttle", newTypingState(now, now), true},
+		{"throttle elapsed", newTypingState(now, now.Add(-typingThrottle)), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &typingTracker{states: make(map[typingKey]*typingState)}
+			if tt.state != nil {
+				tracker.states[typingKey{1, 2}] = tt.state
+			}
+			if got := tracker.extend(1, 2); got != tt.want {
+				t.Errorf("extend = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypingStartAndExpire(t *testing.T) {
+	h := useTestHub(t)
+	typist := connectTestClient(h, 1, 1, flushRoom)
+	peer := connectTestClient(h, 2, 1, flushRoom)
+
+	tracker := &typingTracker{states: make(map[typingKey]*typingState)}
+	tracker.start(1, 1, "alice")
+	tracker.states[typingKey{1, 1}].timer.Stop()
+
+	// 入力した本人には送らない
+	if frames := framesUntilFlush(t, h, typist); len(frames) != 0 {
+		t.Errorf("typist got %v, want nothing", frames)
+	}
+	frames := framesUntilFlush(t, h, peer)
+	if len(frames) != 1 {
+		t.Fatalf("peer got %v, want one typing frame", frames)
+	}
+	var ev struct {
+		Type       string `json:"type"`
+		SenderID   uint   `json:"sender_id"`
+		SenderName string `json:"sender_name"`
+	}
+	json.Unmarshal([]byte(frames[0]), &ev)
+	if ev.Type != "typing" || ev.SenderID != 1 || ev.SenderName != "alice" {
+		t.Errorf("typing frame = %s", frames[0])
+	}
+
+	tests := []struct {
+		name     string
+		lastSeen time.Time
+		wantStop bool
+	}{
+		{"still typing", time.Now(), false},
+		{"timed out", time.Now().Add(-typingTimeout), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := typingKey{1, 1}
+			tracker.states[key] = newTypingState(tt.lastSeen, tt.lastSeen)
+			tracker.expire(key)
+
+			frames := framesUntilFlush(t, h, peer)
+			gotStop := len(frames) == 1 && json.Unmarshal([]byte(frames[0]), &ev) == nil && ev.Type == "typing_stop"
+			if gotStop != tt.wantStop || len(frames) > 1 {
+				t.Errorf("frames = %v, want typing_stop = %v", frames, tt.wantStop)
+			}
+			if _, ok := tracker.states[key]; ok == tt.wantStop {
+				t.Errorf("state kept = %v, want %v", ok, !tt.wantStop)
+			}
+		})
+	}
+}
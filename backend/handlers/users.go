@@ -29,7 +29,7 @@ func GetUsersHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, users)
 }
 
-// ユーザー追加
+// ユーザー追加（POST /rooms/:roomId/members）
 func AddMemberHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
@@ -71,18 +71,29 @@ func AddMemberHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// 5. ログ出力
+		// 5. 追加されたユーザーの接続中WebSocketをルームに参加させる
+		hub.SubscribeUser(req.UserID, roomID)
+
+		// 6. ログ出力
 		log.Printf("✅ User %d added user %d to room %d", currentUserID, req.UserID, roomID)
 
-		// 6. レスポンス
+		// 7. レスポンス
 		c.JSON(http.StatusOK, gin.H{"message": "member added"})
 	}
 }
 
-// ユーザー削除
+// ユーザー削除（DELETE /rooms/:roomId/members/:userId）
 func RemoveMemberHandler(c *gin.Context) {
-	roomID := c.Param("roomId")
-	userID := c.Param("userId")
+	roomID, err := strconv.ParseUint(c.Param("roomId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room ID"})
+		return
+	}
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
 	currentUserID := GetCurrentUserID(c)
 
 	// 自分がそのルームのメンバーであることを確認
@@ -105,5 +116,8 @@ func RemoveMemberHandler(c *gin.Context) {
 		return
 	}
 
+	// 削除されたユーザーの接続中WebSocketをルームから外す
+	hub.UnsubscribeUser(target.UserID, target.RoomID)
+
 	c.JSON(http.StatusOK, gin.H{"message": "member removed"})
 }
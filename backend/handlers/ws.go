@@ -2,8 +2,12 @@
 package handlers
 
 import (
+	"backend/database"
 	"backend/models"
+	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -17,8 +21,7 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-// クライアント接続管理
-var roomClients = make(map[uint][]*websocket.Conn)
+// 保存済みメッセージの配信キュー（StartBroadcast が読み出す）
 var broadcast = make(chan models.Message)
 
 // WebSocket 接続開始用ハンドラ
@@ -32,28 +35,51 @@ func HandleWebSocket(db *gorm.DB) http.HandlerFunc {
 
 		// ✅ クエリからJWTトークン取得
 		token := r.URL.Query().Get("token")
-		log.Println("🔍 token from client:", token)
-		roomIDStr := r.URL.Query().Get("room_id")
-		if token == "" || roomIDStr == "" {
+		log.Println("🔍 token from client:", token[:min(len(token), 10)]+"...")
+		if token == "" {
 			log.Println("❌ token is missing")
-			http.Error(w, "Missing token or room_id", http.StatusBadRequest)
+			http.Error(w, "Missing token", http.StatusBadRequest)
 			return
 		}
 
 		// ✅ トークンを検証して user_id を取得
-		userID, err := ParseJWT(token)
+		claims, err := ParseAccessToken(token)
 		if err != nil {
 			log.Println("❌ token parse error:", err)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if !claims.HasScope(ScopeMessagesRead) {
+			http.Error(w, "insufficient_scope", http.StatusForbidden)
+			return
+		}
+		userID := claims.UserID
 
-		roomID64, err := strconv.ParseUint(roomIDStr, 10, 64)
+		// ✅ 所属している全ルームを購読対象にする
+		rooms, err := loadMemberRooms(db, userID)
 		if err != nil {
-			http.Error(w, "Invalid room_id", http.StatusBadRequest)
+			log.Println("❌ room_members fetch error:", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+
+		// 旧クライアント互換: room_id 指定があればメンバーであることを確認
+		var requestedRoomID uint
+		if roomIDStr := r.URL.Query().Get("room_id"); roomIDStr != "" {
+			roomID64, err := strconv.ParseUint(roomIDStr, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid room_id", http.StatusBadRequest)
+				return
+			}
+			requestedRoomID = uint(roomID64)
+		}
+
+		// ✅ 再接続時の再開位置（指定がなければ再送しない）
+		cursors, err := parseReplayCursors(r.URL.Query().Get("last_message_id"), r.URL.Query().Get("cursors"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		roomID := uint(roomID64)
 
 		// ✅ WebSocket接続をアップグレード（検証後！）
 		conn, err := upgrader.Upgrade(w, r, nil)
@@ -61,60 +87,259 @@ func HandleWebSocket(db *gorm.DB) http.HandlerFunc {
 			log.Println("Upgrade error:", err)
 			return
 		}
-		defer conn.Close()
-
 		log.Println("🔌 WebSocket client connected")
 
-		// 接続登録
-		roomClients[roomID] = append(roomClients[roomID], conn)
-		log.Printf("🔗 User %d connected to room %d\n", userID, roomID)
+		// ✅ pong が届くたびに読み込み期限を延ばす（届かなければ ReadJSON がタイムアウト）
+		conn.SetReadLimit(maxFrameSize)
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+
+		// メンバーでないルームを指定された場合はエラーフレームを返して切断
+		if requestedRoomID != 0 && !rooms[requestedRoomID] {
+			log.Printf("🚫 User %d tried to connect to room %d without membership\n", userID, requestedRoomID)
+			conn.WriteJSON(notMemberFrame(requestedRoomID))
+			conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "not a member"))
+			conn.Close()
+			return
+		}
+
+		// 再送対象のルーム（登録後の rooms はハブのゴルーチンだけが触るので複製しておく）
+		replayRooms := make(map[uint]bool, len(rooms))
+		for id := range rooms {
+			replayRooms[id] = true
+		}
+
+		// 接続登録（送信はハブ経由で writePump が行う）
+		client := &Client{
+			hub:       hub,
+			conn:      conn,
+			userID:    userID,
+			sessionID: claims.SessionID,
+			rooms:     rooms,
+			send:      make(chan []byte, sendQueueSize),
+		}
+		hub.register <- client
+
+		// ✅ 取りこぼしたイベントを先に送ってからライブ配信に切り替える
+		// （登録後に届いたライブイベントは送信キューに溜まり、writePump 開始後に送られる。
+		//   再送したメッセージと同じものは writePump が捨てる）
+		if len(cursors) > 0 {
+			client.markReplayed(replayMissedEvents(db, userID, replayRooms, cursors, func(payload interface{}) error {
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				return conn.WriteJSON(payload)
+			}))
+			conn.SetReadDeadline(time.Now().Add(pongWait))
+		}
+		go client.writePump()
+		presence.connected(userID)
 
 		defer func() {
-			// 切断時にリストから削除
-			conns := roomClients[roomID]
-			for i, c := range conns {
-				if c == conn {
-					roomClients[roomID] = append(conns[:i], conns[i+1:]...)
-					break
-				}
-			}
+			// 切断時にハブから削除
+			hub.unregister <- client
 			conn.Close()
+			presence.disconnected(userID)
 		}()
 
 		// メッセージ受信ループ
 		for {
-			var msg models.Message
-			if err := conn.ReadJSON(&msg); err != nil {
-				log.Println("Read error:", err)
+			var frame models.WSClientFrame
+			if err := conn.ReadJSON(&frame); err != nil {
+				var syntaxErr *json.SyntaxError
+				var typeErr *json.UnmarshalTypeError
+				if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+					hub.SendToClient(client, models.WSErrorFrame{
+						Type:    "error",
+						Code:    "invalid_frame",
+						Message: "frame must be a JSON object",
+					})
+					continue
+				}
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					log.Printf("💀 Reaping dead connection: user %d (no pong within %s)\n", userID, pongWait)
+					wsReapedConnections.Add(1)
+				} else {
+					log.Println("Read error:", err)
+				}
 				break
 			}
+			conn.SetReadDeadline(time.Now().Add(pongWait))
 
-			log.Printf("📩 Received message: %+v\n", msg)
-
-			// 🔽 メッセージに現在時刻を追加（CreatedAt は ISO形式で）
-			msg.SenderID = userID
-			msg.CreatedAt = time.Now()
-			msg.Type = "message"
+			presence.touch(userID)
 
 			if db == nil {
 				log.Println("❌ dbInstance is nil")
 				break
 			}
 
-			// 🔽 データベースに保存してIDを確定させる
-			if err := db.Create(&msg).Error; err != nil {
+			switch frame.Type {
+			case "heartbeat":
+				// 在席状況の更新のみ
+				continue
+			case "subscribe", "unsubscribe":
+				handleSubscriptionFrame(db, client, frame)
+				continue
+			case "", "message", "typing":
+				// 送信には messages:write が必要（パーソナルアクセストークンの場合）
+				if !claims.HasScope(ScopeMessagesWrite) {
+					hub.SendToClient(client, models.WSErrorFrame{
+						Type:    "error",
+						Code:    "insufficient_scope",
+						Message: "messages:write scope is required",
+					})
+					continue
+				}
+			default:
+				log.Println("⚠️ Unknown frame type:", frame.Type)
+				hub.SendToClient(client, models.WSErrorFrame{
+					Type:    "error",
+					Code:    "unknown_type",
+					Message: "unknown frame type: " + frame.Type,
+				})
+				continue
+			}
+
+			// 🔽 入力中通知は配信間隔内なら期限の延長だけ（DBアクセスなし）
+			if frame.Type == "typing" && typing.extend(frame.RoomID, userID) {
+				continue
+			}
+
+			// 🔽 送信先ルームのメンバーか確認（接続後に外された場合もここで弾く）
+			if !isRoomMember(db, frame.RoomID, userID) {
+				log.Printf("🚫 User %d tried to post to room %d without membership\n", userID, frame.RoomID)
+				hub.SendToClient(client, notMemberFrame(frame.RoomID))
+				continue
+			}
+
+			// 🔽 入力中通知は保存せずに他のメンバーへ配信
+			if frame.Type == "typing" {
+				var sender models.User
+				db.Select("username").First(&sender, userID)
+				typing.start(frame.RoomID, userID, sender.Username)
+				continue
+			}
+
+			// 🔽 スレッド返信は同じルームの親メッセージ（返信ではないもの）に限る
+			if frame.ThreadRootID != nil {
+				if err := validateThreadRoot(frame.RoomID, *frame.ThreadRootID); err != nil {
+					hub.SendToClient(client, models.WSErrorFrame{
+						Type:    "error",
+						Code:    "invalid_thread_root",
+						Message: err.Error(),
+						RoomID:  frame.RoomID,
+					})
+					continue
+				}
+			}
+
+			// 🔽 メッセージに現在時刻を追加（CreatedAt は ISO形式で）
+			msg := models.Message{
+				RoomID:       frame.RoomID,
+				SenderID:     userID,
+				SenderName:   frame.SenderName,
+				Content:      frame.Content,
+				ThreadRootID: frame.ThreadRootID,
+				ClientMsgID:  frame.ClientMsgID,
+				CreatedAt:    time.Now(),
+				Type:         "message",
+			}
+			if !validClientMsgID(frame.ClientMsgID) {
+				hub.SendToClient(client, models.WSErrorFrame{
+					Type:    "error",
+					Code:    "invalid_frame",
+					Message: "client_msg_id must be 1-64 characters",
+					RoomID:  frame.RoomID,
+				})
+				continue
+			}
+
+			// 🔽 データベースに保存してIDを確定させる（再送なら既存のメッセージが返る）
+			duplicate, err := database.CreateMessage(db, &msg)
+			if err != nil {
 				log.Println("❌DB save error:", err)
 				continue
 			}
-			log.Printf("💾 Message saved with ID: %d\n", msg.ID)
 
-			// 🔽 保存された msg（ID付き）をブロードキャスト
-			broadcast <- msg
-			log.Println("📤 Message enqueued for broadcast")
+			if duplicate {
+				log.Printf("♻️ Duplicate message from user %d (client_msg_id=%s)\n", userID, *msg.ClientMsgID)
+			} else {
+				log.Printf("💾 Message saved with ID: %d\n", msg.ID)
+
+				// 🔽 保存された msg（ID付き）をブロードキャスト
+				broadcast <- msg
+				log.Println("📤 Message enqueued for broadcast")
+			}
+
+			// 🔽 送信者に client_msg_id とサーバー側IDの対応を返す
+			if msg.ClientMsgID != nil {
+				hub.SendToClient(client, models.WSAckFrame{
+					Type:        "ack",
+					ClientMsgID: *msg.ClientMsgID,
+					MessageID:   msg.ID,
+					RoomID:      msg.RoomID,
+					Duplicate:   duplicate,
+				})
+			}
 		}
 	}
 }
 
+// subscribe / unsubscribe フレームの処理
+// subscribe は room_members に登録されているルームに限る
+func handleSubscriptionFrame(db *gorm.DB, client *Client, frame models.WSClientFrame) {
+	if frame.Type == "unsubscribe" {
+		client.hub.subscribe <- subscription{client: client, roomID: frame.RoomID, join: false}
+		return
+	}
+
+	if !isRoomMember(db, frame.RoomID, client.userID) {
+		log.Printf("🚫 User %d tried to subscribe to room %d without membership\n", client.userID, frame.RoomID)
+		client.hub.SendToClient(client, notMemberFrame(frame.RoomID))
+		return
+	}
+	client.hub.subscribe <- subscription{client: client, roomID: frame.RoomID, join: true}
+}
+
+// ユーザーが所属している全ルーム
+func loadMemberRooms(db *gorm.DB, userID uint) (map[uint]bool, error) {
+	var roomIDs []uint
+	if err := db.Model(&models.RoomMember{}).
+		Where("user_id = ?", userID).
+		Pluck("room_id", &roomIDs).Error; err != nil {
+		return nil, err
+	}
+	rooms := make(map[uint]bool)
+	for _, id := range roomIDs {
+		rooms[id] = true
+	}
+	return rooms, nil
+}
+
+// room_members にユーザーが登録されているか
+func isRoomMember(db *gorm.DB, roomID, userID uint) bool {
+	var count int64
+	if err := db.Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id = ?", roomID, userID).
+		Count(&count).Error; err != nil {
+		log.Println("❌ room_members check error:", err)
+		return false
+	}
+	return count > 0
+}
+
+// メンバーでないルームへの操作に返すエラーフレーム
+func notMemberFrame(roomID uint) models.WSErrorFrame {
+	return models.WSErrorFrame{
+		Type:    "error",
+		Code:    "not_a_member",
+		Message: "not a member of this room",
+		RoomID:  roomID,
+	}
+}
+
 // 全クライアントにメッセージを送信する処理
 func StartBroadcast() {
 	for {
@@ -125,40 +350,58 @@ func StartBroadcast() {
 		var attachments []models.MessageAttachment
 		db.Where("message_id = ?", msg.ID).Find(&attachments)
 
-		// 保存されたmsgから送信用データを作る
-		wsMsg := models.WSMessage{
-			Type:       "message",
-			ID:         msg.ID,
-			RoomID:     msg.RoomID,
-			SenderID:   msg.SenderID,
-			SenderName: msg.SenderName, // 必要ならDBから取得
-			Content:    msg.Content,
-			CreatedAt:  msg.CreatedAt.Format(time.RFC3339),
-		}
+		// ✅ そのルームの接続者にだけブロードキャスト
+		hub.BroadcastToRoom(msg.RoomID, toWSMessage(msg, attachments))
+	}
+}
 
-		for _, att := range attachments {
-			wsMsg.Attachments = append(wsMsg.Attachments, models.MessageAttachment{
-				FileName: att.FileName,
-			})
-		}
+// 保存されたmsgから送信用データを作る
+// スレッドの返信は thread_reply として送る（ルームのタイムラインには出さず、スレッド表示だけを更新してもらう）
+func toWSMessage(msg models.Message, attachments []models.MessageAttachment) models.WSMessage {
+	wsMsg := models.WSMessage{
+		Type:         "message",
+		ID:           msg.ID,
+		ThreadRootID: msg.ThreadRootID,
+		RoomID:       msg.RoomID,
+		SenderID:     msg.SenderID,
+		SenderName:   msg.SenderName, // 必要ならDBから取得
+		Content:      msg.Content,
+		CreatedAt:    msg.CreatedAt.Format(time.RFC3339),
+		ClientMsgID:  msg.ClientMsgID,
+	}
+	if msg.ThreadRootID != nil {
+		wsMsg.Type = "thread_reply"
+	}
 
-		// ✅ そのルームの接続者にだけブロードキャスト
-		for _, client := range roomClients[msg.RoomID] {
-			if err := client.WriteJSON(wsMsg); err != nil {
-				log.Println("❌ Write error:", err)
-				client.Close()
-			}
-		}
+	for _, att := range attachments {
+		wsMsg.Attachments = append(wsMsg.Attachments, models.MessageAttachment{
+			FileName: att.FileName,
+		})
+	}
+	return wsMsg
+}
 
+// 編集通知
+func updateEvent(msg models.Message) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "update",
+		"message_id":  msg.ID,
+		"room_id":     msg.RoomID,
+		"new_content": msg.Content,
+		"edited_at":   msg.EditedAt,
 	}
 }
 
-func BroadcastToRoom(roomID uint, payload interface{}) {
-	clients := roomClients[roomID]
-	for _, client := range clients {
-		if err := client.WriteJSON(payload); err != nil {
-			log.Println("❌ BroadcastToRoom Write error:", err)
-			client.Close()
-		}
+// 削除通知
+func deleteEvent(msg models.Message) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "delete",
+		"message_id": msg.ID,
+		"room_id":    msg.RoomID,
 	}
 }
+
+// 指定ルームの接続者全員に送る（送信はハブに任せる）
+func BroadcastToRoom(roomID uint, payload interface{}) {
+	hub.BroadcastToRoom(roomID, payload)
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"backend/models"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSubscribeUserAcrossConnections(t *testing.T) {
+	tests := []struct {
+		name  string
+		ops   []subscription // client は使わず userID で指定
+		room  uint
+		wantA bool // user 1 の接続が受け取るか
+		wantB bool // user 2 の接続が受け取るか
+	}{
+		{"subscribe adds the room", []subscription{{userID: 1, roomID: 5, join: true}}, 5, true, false},
+		{"unsubscribe removes the room", []subscription{{userID: 1, roomID: 1, join: false}}, 1, false, true},
+		{"resubscribe", []subscription{{userID: 1, roomID: 1, join: false}, {userID: 1, roomID: 1, join: true}}, 1, true, true},
+		{"only the target user", []subscription{{userID: 2, roomID: 5, join: true}}, 5, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := startTestHub(t)
+			// user 1 は2つの接続（どちらにも同じように反映される）
+			a1 := connectTestClient(h, 1, 1, flushRoom)
+			a2 := connectTestClient(h, 1, 1, flushRoom)
+			b := connectTestClient(h, 2, 1, flushRoom)
+
+			for _, op := range tt.ops {
+				if op.join {
+					h.SubscribeUser(op.userID, op.roomID)
+				} else {
+					h.UnsubscribeUser(op.userID, op.roomID)
+				}
+			}
+			h.BroadcastToRoom(tt.room, "hello")
+
+			for _, c := range []struct {
+				client *Client
+				want   bool
+			}{{a1, tt.wantA}, {a2, tt.wantA}, {b, tt.wantB}} {
+				frames := framesUntilFlush(t, h, c.client)
+				if got := len(frames) == 1; got != c.want {
+					t.Errorf("user %d: frames = %v, want received = %v", c.client.userID, frames, c.want)
+				}
+			}
+		})
+	}
+}
+
+// unsubscribe フレームはその接続だけを外す（同じユーザーの他の接続はそのまま）
+func TestUnsubscribeFrameAffectsOnlyThatConnection(t *testing.T) {
+	h := startTestHub(t)
+	laptop := connectTestClient(h, 1, 1, 2, flushRoom)
+	phone := connectTestClient(h, 1, 1, 2, flushRoom)
+
+	// unsubscribe は DB を使わない
+	handleSubscriptionFrame(nil, laptop, models.WSClientFrame{Type: "unsubscribe", RoomID: 1})
+	h.BroadcastToRoom(1, "room1")
+	h.BroadcastToRoom(2, "room2")
+
+	tests := []struct {
+		client *Client
+		want   []string
+	}{
+		{laptop, []string{`"room2"`}},
+		{phone, []string{`"room1"`, `"room2"`}},
+	}
+	for i, tt := range tests {
+		frames := framesUntilFlush(t, h, tt.client)
+		if len(frames) != len(tt.want) {
+			t.Errorf("connection %d: frames = %v, want %v", i, frames, tt.want)
+			continue
+		}
+		for j := range frames {
+			if frames[j] != tt.want[j] {
+				t.Errorf("connection %d: frames = %v, want %v", i, frames, tt.want)
+			}
+		}
+	}
+}
+
+func TestIsRoomMember(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	room := createTestRoom(t, alice.ID)
+	other := createTestRoom(t, bob.ID)
+
+	// 退出したメンバー（論理削除）
+	left := createTestUser(t, "carol")
+	testDB.Create(&models.RoomMember{RoomID: room, UserID: left.ID})
+	testDB.Where("room_id = ? AND user_id = ?", room, left.ID).Delete(&models.RoomMember{})
+
+	tests := []struct {
+		name   string
+		roomID uint
+		userID uint
+		want   bool
+	}{
+		{"member", room, alice.ID, true},
+		{"not a member", room, bob.ID, false},
+		{"member of another room", other, alice.ID, false},
+		{"left the room", room, left.ID, false},
+		{"unknown room", other + 100, alice.ID, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRoomMember(testDB, tt.roomID, tt.userID); got != tt.want {
+				t.Errorf("isRoomMember(%d, %d) = %v, want %v", tt.roomID, tt.userID, got, tt.want)
+			}
+		})
+	}
+}
+
+// テスト用サーバーに WebSocket で接続する
+func dialTestWS(t *testing.T, server *httptest.Server, userID uint, query string) *websocket.Conn {
+	t.Helper()
+	token, err := generateAccessToken(userID, "test-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?token=" + token + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// 次のフレームを読む（type と room_id だけ見る）
+func readTestFrame(t *testing.T, conn *websocket.Conn) (frame struct {
+	Type   string `json:"type"`
+	Code   string `json:"code"`
+	RoomID uint   `json:"room_id"`
+}) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatal("read frame:", err)
+	}
+	return frame
+}
+
+func TestWebSocketRequiresMembership(t *testing.T) {
+	setupTestDB(t)
+	useTestKeys(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	room := createTestRoom(t, alice.ID)
+	bobRoom := createTestRoom(t, bob.ID)
+
+	server := httptest.NewServer(HandleWebSocket(testDB))
+	defer server.Close()
+
+	t.Run("connect to a room without membership", func(t *testing.T) {
+		conn := dialTestWS(t, server, alice.ID, fmt.Sprintf("&room_id=%d", bobRoom))
+		if f := readTestFrame(t, conn); f.Type != "error" || f.Code != "not_a_member" || f.RoomID != bobRoom {
+			t.Errorf("frame = %+v, want not_a_member for room %d", f, bobRoom)
+		}
+		if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+			t.Errorf("read after error = %v, want policy violation close", err)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		frame    models.WSClientFrame
+		wantType string
+		wantCode string
+	}{
+		{"message to own room", models.WSClientFrame{Type: "message", RoomID: room, Content: "hi"}, "message", ""},
+		{"message to another room", models.WSClientFrame{Type: "message", RoomID: bobRoom, Content: "hi"}, "error", "not_a_member"},
+		{"typing in another room", models.WSClientFrame{Type: "typing", RoomID: bobRoom}, "error", "not_a_member"},
+		{"subscribe to another room", models.WSClientFrame{Type: "subscribe", RoomID: bobRoom}, "error", "not_a_member"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialTestWS(t, server, alice.ID, "")
+			if err := conn.WriteJSON(tt.frame); err != nil {
+				t.Fatal(err)
+			}
+			f := readTestFrame(t, conn)
+			if f.Type != tt.wantType || f.Code != tt.wantCode || f.RoomID != tt.frame.RoomID {
+				t.Errorf("frame = %+v, want type %q code %q room %d", f, tt.wantType, tt.wantCode, tt.frame.RoomID)
+			}
+		})
+	}
+
+	// メンバーでないルームにはメッセージが保存されない
+	var count int64
+	testDB.Model(&models.Message{}).Where("room_id = ?", bobRoom).Count(&count)
+	if count != 0 {
+		t.Errorf("messages in room %d = %d, want 0", bobRoom, count)
+	}
+}
+
+// メンバーの追加・削除は、接続中のソケットの購読にそのまま反映される
+func TestMemberChangesUpdateLiveSubscriptions(t *testing.T) {
+	setupTestDB(t)
+	h := useTestHub(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	room := createTestRoom(t, alice.ID)
+	bobConn := connectTestClient(h, bob.ID, flushRoom)
+
+	add := func(userID uint, roomID string, target uint) int {
+		w := serveAs(userID, "POST", "/rooms/:roomId/members", "/rooms/"+roomID+"/members",
+			map[string]uint{"userId": target}, AddMemberHandler(testDB))
+		return w.Code
+	}
+	remove := func(userID uint, roomID string, target string) int {
+		w := serveAs(userID, "DELETE", "/rooms/:roomId/members/:userId", "/rooms/"+roomID+"/members/"+target,
+			nil, RemoveMemberHandler)
+		return w.Code
+	}
+	roomStr := fmt.Sprint(room)
+	bobStr := fmt.Sprint(bob.ID)
+
+	tests := []struct {
+		name        string
+		do          func() int
+		wantStatus  int
+		wantReceive bool // 操作後にルームへのブロードキャストが bob の接続に届くか
+	}{
+		{"invalid room id", func() int { return add(alice.ID, "x", bob.ID) }, http.StatusBadRequest, false},
+		{"added by a non-member", func() int { return add(carol.ID, roomStr, bob.ID) }, http.StatusForbidden, false},
+		{"add", func() int { return add(alice.ID, roomStr, bob.ID) }, http.StatusOK, true},
+		{"invalid user id", func() int { return remove(alice.ID, roomStr, "x") }, http.StatusBadRequest, true},
+		{"removed by a non-member", func() int { return remove(carol.ID, roomStr, bobStr) }, http.StatusForbidden, true},
+		{"remove", func() int { return remove(alice.ID, roomStr, bobStr) }, http.StatusOK, false},
+		{"remove again", func() int { return remove(alice.ID, roomStr, bobStr) }, http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.do(); got != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", got, tt.wantStatus)
+			}
+			h.BroadcastToRoom(room, "hello")
+			frames := framesUntilFlush(t, h, bobConn)
+			if got := len(frames) == 1; got != tt.wantReceive {
+				t.Errorf("frames = %v, want received = %v", frames, tt.wantReceive)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ローカル開発用の実装（送らずに .eml ファイルとして保存する）
+type File struct {
+	dir  string
+	from string
+}
+
+func NewFile(dir, from string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &File{dir: dir, from: from}, nil
+}
+
+func (f *File) Send(msg Message) error {
+	data, err := build(f.from, msg)
+	if err != nil {
+		return err
+	}
+
+	// 宛先はファイル名に使えない文字を置き換える
+	to := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, msg.To)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), to)
+	return os.WriteFile(filepath.Join(f.dir, name), data, 0o644)
+}
@@ -0,0 +1,100 @@
+package mailer
+
+import (
+	"encoding/base64"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		msg     Message
+		wantErr bool
+	}{
+		{"japanese subject and body", "chat_app <no-reply@example.com>",
+			Message{To: "alice@example.com", Subject: "【chat_app】パスワードの再設定", Body: strings.Repeat("本文です。", 30)}, false},
+		{"line break in to", "no-reply@example.com",
+			Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "s", Body: "b"}, true},
+		{"line break in subject", "no-reply@example.com",
+			Message{To: "alice@example.com", Subject: "s\nBcc: eve@example.com", Body: "b"}, true},
+		{"line break in from", "no-reply@example.com\n",
+			Message{To: "alice@example.com", Subject: "s", Body: "b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := build(tt.from, tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			head, body, _ := strings.Cut(string(data), "\r\n\r\n")
+			headers := map[string]string{}
+			for _, line := range strings.Split(head, "\r\n") {
+				k, v, _ := strings.Cut(line, ": ")
+				headers[k] = v
+			}
+			if headers["From"] != tt.from || headers["To"] != tt.msg.To {
+				t.Errorf("headers = %v", headers)
+			}
+			if subject, err := new(mime.WordDecoder).DecodeHeader(headers["Subject"]); err != nil || subject != tt.msg.Subject {
+				t.Errorf("subject = %q (%v), want %q", subject, err, tt.msg.Subject)
+			}
+
+			// 本文は base64 で1行76文字以内
+			lines := strings.Split(strings.TrimSuffix(body, "\r\n"), "\r\n")
+			for _, line := range lines {
+				if len(line) > 76 {
+					t.Errorf("body line longer than 76: %d", len(line))
+				}
+			}
+			decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+			if err != nil || string(decoded) != tt.msg.Body {
+				t.Errorf("body = %q (%v), want %q", decoded, err, tt.msg.Body)
+			}
+		})
+	}
+}
+
+func TestFileSend(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	f, err := NewFile(dir, "no-reply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Send(Message{To: "a/b:c@example.com", Subject: "s", Body: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), "-a_b_c@example.com.eml") {
+		t.Fatalf("files = %v, want one .eml with the sanitized address", files)
+	}
+	if err := f.Send(Message{To: "x@example.com", Subject: "s\r\n", Body: "b"}); err == nil {
+		t.Error("header injection was not rejected")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	msgs := []Message{{To: "a@example.com"}, {To: "b@example.com"}}
+	for _, msg := range msgs {
+		m.Send(msg)
+	}
+	sent := m.Sent()
+	if len(sent) != 2 || sent[0].To != "a@example.com" || sent[1].To != "b@example.com" {
+		t.Errorf("sent = %+v, want in order", sent)
+	}
+	// 返した一覧を書き換えても保持している内容は変わらない
+	sent[0].To = "changed"
+	if m.Sent()[0].To != "a@example.com" {
+		t.Error("Sent returned the internal slice")
+	}
+}
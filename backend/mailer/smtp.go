@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+// SMTP サーバー経由で送る実装
+type SMTP struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// username が空なら認証なしで送る
+func NewSMTP(host, port, username, password, from string) *SMTP {
+	return &SMTP{host: host, port: port, username: username, password: password, from: from}
+}
+
+func (s *SMTP) Send(msg Message) error {
+	data, err := build(s.from, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+	// エンベロープの送信者には表示名を含めない
+	sender := s.from
+	if addr, err := mail.ParseAddress(s.from); err == nil {
+		sender = addr.Address
+	}
+	return smtp.SendMail(net.JoinHostPort(s.host, s.port), auth, sender, []string{msg.To}, data)
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"backend/database"
+	"backend/fanout"
 	"backend/handlers"
+	"backend/mailer"
 	"backend/models"
 
+	"expvar"
 	"log"
+	"net/http"
+	"os"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -14,8 +20,9 @@ import (
 
 var db *gorm.DB
 
+const dsn = "host=db user=user password=password dbname=chat_app_db port=5432 sslmode=disable"
+
 func InitDB() *gorm.DB {
-	dsn := "host=db user=user password=password dbname=chat_app_db port=5432 sslmode=disable"
 	var err error
 	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
@@ -23,10 +30,14 @@ func InitDB() *gorm.DB {
 	}
 
 	// DB接続後のマイグレーションなど
-	err = db.AutoMigrate(&models.User{}, &models.Message{}, &models.ChatRoom{}, &models.RoomMember{}, &models.MessageRead{})
+	err = db.AutoMigrate(&models.User{}, &models.Message{}, &models.ChatRoom{}, &models.RoomMember{}, &models.MessageRead{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.Session{}, &models.RecoveryCode{}, &models.LoginThrottle{}, &models.AuditLog{}, &models.PersonalAccessToken{}, &models.MessageReaction{}, &models.MessageRevision{}, &models.Presence{})
 	if err != nil {
 		log.Fatal("❌Failed to migrate database:", err)
 	}
+	// メッセージ検索用（全文検索の生成カラム・pg_trgm）
+	if err := database.MigrateSearch(db); err != nil {
+		log.Fatal("❌Failed to migrate search indexes:", err)
+	}
 
 	log.Println("✅Connected to the database!")
 	return db
@@ -36,9 +47,45 @@ func main() {
 	db := InitDB() // DB接続
 
 	handlers.SetDB(db)
+	handlers.LoadJWTKeys()
+
+	// 複数台構成では FANOUT_BACKEND=postgres でサーバー間に配信する（既定は1台構成用のメモリ内配信）
+	if os.Getenv("FANOUT_BACKEND") == "postgres" {
+		pg, err := fanout.NewPostgres(dsn, db)
+		if err != nil {
+			log.Fatal("❌ fanout初期化失敗:", err)
+		}
+		defer pg.Close()
+		handlers.SetFanout(pg)
+	}
+
+	// メール送信（MAILER=smtp で SMTP、既定はローカル確認用に MAIL_DIR へ .eml を保存）
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "chat_app <no-reply@localhost>"
+	}
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		handlers.SetMailer(mailer.NewSMTP(os.Getenv("SMTP_HOST"), os.Getenv("SMTP_PORT"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from))
+	case "memory":
+		handlers.SetMailer(mailer.NewMemory())
+	default:
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "./mail"
+		}
+		fm, err := mailer.NewFile(dir, from)
+		if err != nil {
+			log.Fatal("❌ mailer初期化失敗:", err)
+		}
+		handlers.SetMailer(fm)
+	}
 
-	// ✅ WebSocket中継処理を並列で起動
+	// ✅ WebSocketハブと中継処理を並列で起動
+	go handlers.StartHub()
 	go handlers.StartBroadcast()
+	go handlers.StartPresence()
+	go handlers.StartSessionTouchSweeper()
 
 	r := gin.Default()
 
@@ -54,35 +101,79 @@ func main() {
 	// 認証が不要なAPIエンドポイント
 	r.POST("/signup", handlers.SignUpHandler(db))
 	r.POST("/login", handlers.LoginHandler)
+	r.POST("/login/2fa", handlers.LoginTwoFactorHandler) // 二要素認証（ログインの2段階目）
+	r.POST("/auth/refresh", handlers.RefreshHandler(db))
+	r.GET("/.well-known/jwks.json", handlers.JWKSHandler)
+	r.GET("/auth/oidc/login", handlers.OIDCLoginHandler)       // シングルサインオン開始（IdP へリダイレクト）
+	r.GET("/auth/oidc/callback", handlers.OIDCCallbackHandler) // IdP からの戻り先
+	r.POST("/email/verify", handlers.VerifyEmailHandler)       // メールアドレス確認
+	r.POST("/password/forgot", handlers.ForgotPasswordHandler) // パスワード再設定メールの送信
+	r.POST("/password/reset", handlers.ResetPasswordHandler)   // パスワード再設定
 
 	// 認証が必要なAPIエンドポイント
+	// （パーソナルアクセストークンでは、グループごとに必要なスコープを持つものだけが通る）
 	auth := r.Group("/")
 	auth.Use(handlers.AuthMiddleware())
 
 	// 認証情報
 	auth.GET("/me", handlers.MeHandler(db))
 
+	// アカウント管理（ログインしたユーザー本人のみ。パーソナルアクセストークンでは使えない）
+	account := auth.Group("/", handlers.RequireScope(handlers.ScopeAccount))
+	account.POST("/logout", handlers.LogoutHandler)                   // ログアウト
+	account.POST("/logout/all", handlers.LogoutAllHandler)            // 全端末からログアウト
+	account.POST("/email/resend", handlers.ResendVerificationHandler) // 確認メールの再送
+	account.PUT("/me/password", handlers.ChangePasswordHandler)       // パスワード変更
+
+	// セッション管理
+	account.GET("/me/sessions", handlers.GetSessionsHandler)          // ログイン中の端末一覧
+	account.DELETE("/me/sessions/:id", handlers.DeleteSessionHandler) // 端末のセッションを終了
+
+	// 二要素認証（TOTP）
+	account.POST("/me/2fa/setup", handlers.SetupTwoFactorHandler)   // 認証アプリ登録用の鍵を発行
+	account.POST("/me/2fa/verify", handlers.VerifyTwoFactorHandler) // コードを確認して有効化
+
+	// パーソナルアクセストークン・ボット
+	account.GET("/me/tokens", handlers.GetPersonalTokensHandler)          // 発行済みトークン一覧
+	account.POST("/me/tokens", handlers.CreatePersonalTokenHandler)       // トークン発行
+	account.DELETE("/me/tokens/:id", handlers.DeletePersonalTokenHandler) // トークン失効
+	account.GET("/bots", handlers.GetBotsHandler)                         // 自分のボット一覧
+	account.POST("/bots", handlers.CreateBotHandler)                      // ボット作成
+
 	// ユーザー関連
-	auth.GET("/users", handlers.GetUsersHandler)        // ユーザー一覧取得
-	auth.POST("/users", handlers.AddMemberHandler(db))  //メンバー追加
-	auth.DELETE("/users", handlers.RemoveMemberHandler) //メンバー削除
+	usersRead := auth.Group("/", handlers.RequireScope(handlers.ScopeUsersRead))
+	usersRead.GET("/users", handlers.GetUsersHandler)             // ユーザー一覧取得
+	usersRead.GET("/users/presence", handlers.GetPresenceHandler) // 在席状況取得
 
 	// ルーム関連
-	auth.GET("/rooms", handlers.GetRoomHandler(db))            //ルーム一覧取得
-	auth.POST("/rooms", handlers.CreateRoomHandler(db))        //ルーム作成✅
-	auth.GET("/rooms/group", handlers.GetGrouproomHandler)     //ルーム一覧取得（グループ）
-	auth.POST("/rooms/group", handlers.CreateGrouproomHandler) //ルーム作成（グループ）
+	roomsRead := auth.Group("/", handlers.RequireScope(handlers.ScopeRoomsRead))
+	roomsRead.GET("/rooms", handlers.GetRoomHandler(db))        //ルーム一覧取得
+	roomsRead.GET("/rooms/group", handlers.GetGrouproomHandler) //ルーム一覧取得（グループ）
+
+	roomsWrite := auth.Group("/", handlers.RequireScope(handlers.ScopeRoomsWrite))
+	roomsWrite.POST("/rooms", handlers.CreateRoomHandler(db))                         //ルーム作成✅
+	roomsWrite.POST("/rooms/group", handlers.CreateGrouproomHandler)                  //ルーム作成（グループ）
+	roomsWrite.POST("/rooms/:roomId/members", handlers.AddMemberHandler(db))          //メンバー追加
+	roomsWrite.DELETE("/rooms/:roomId/members/:userId", handlers.RemoveMemberHandler) //メンバー削除
 
 	// メッセージ関連
-	auth.GET("/messages", handlers.GetMessagesHandler)             // メッセージ取得
-	auth.POST("/messages", handlers.SendMessageHandler)            // メッセージ送信
-	auth.GET("/messages/group", handlers.GetGroupMessagesHandler)  // メッセージ取得（グループ）
-	auth.POST("/messages/group", handlers.SendGroupMessageHandler) // メッセージ送信（グループ）
-	auth.POST("/messages/:id/read", handlers.MarkMessageAsRead)    // ✅ 既読記録
-	auth.POST("/messages/read_all", handlers.MarkAllMessagesAsRead)
+	messagesRead := auth.Group("/", handlers.RequireScope(handlers.ScopeMessagesRead))
+	messagesRead.GET("/messages", handlers.GetMessagesHandler)                   // メッセージ取得
+	messagesRead.GET("/messages/group", handlers.GetGroupMessagesHandler)        // メッセージ取得（グループ）
+	messagesRead.GET("/search/messages", handlers.SearchMessagesHandler)         // メッセージ検索
+	messagesRead.GET("/messages/:id/thread", handlers.GetThreadHandler)          // スレッド（返信一覧）
+	messagesRead.GET("/messages/:id/history", handlers.GetMessageHistoryHandler) // 編集履歴
+
+	messagesWrite := auth.Group("/", handlers.RequireScope(handlers.ScopeMessagesWrite))
+	messagesWrite.POST("/messages", handlers.SendMessageHandler)            // メッセージ送信
+	messagesWrite.POST("/messages/group", handlers.SendGroupMessageHandler) // メッセージ送信（グループ）
+	messagesWrite.POST("/messages/:id/read", handlers.MarkMessageAsRead)    // ✅ 既読記録
+	messagesWrite.POST("/messages/read_all", handlers.MarkAllMessagesAsRead)
 	// メッセージ編集・削除
-	auth.PATCH("/messages/:id", handlers.UpdateMessageHandler(db))
-	auth.DELETE("/messages/:id", handlers.DeleteMessageHandler(db))
+	messagesWrite.PATCH("/messages/:id", handlers.UpdateMessageHandler(db))
+	messagesWrite.DELETE("/messages/:id", handlers.DeleteMessageHandler(db))
+	messagesWrite.POST("/messages/:id/reactions/:emoji", handlers.AddReactionHandler)      // リアクション追加
+	messagesWrite.DELETE("/messages/:id/reactions/:emoji", handlers.RemoveReactionHandler) // リアクション削除
 
 	//メンション
 	r.GET("/mentions", handlers.GetMentionsHandler)
@@ -93,6 +184,27 @@ func main() {
 	// ✅ WebSocket エンドポイント (Ginで登録)
 	r.GET("/ws", gin.WrapF(handlers.HandleWebSocket(db)))
 
+	// ✅ Server-Sent Events（WebSocket が使えない環境向けのフォールバック）
+	r.GET("/events", handlers.EventsHandler(db))
+
+	// メトリクス（WebSocket の接続数・切断数など）
+	// 起動コマンドやメモリ情報も含むので、公開ポートではなく内部向けのアドレスでだけ待ち受ける
+	// DEBUG_ADDR=off で無効化
+	debugAddr := os.Getenv("DEBUG_ADDR")
+	if debugAddr == "" {
+		debugAddr = "127.0.0.1:9090"
+	}
+	if debugAddr != "off" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			log.Printf("📈 Metrics on http://%s/debug/vars\n", debugAddr)
+			if err := http.ListenAndServe(debugAddr, mux); err != nil {
+				log.Println("❌ metrics listener error:", err)
+			}
+		}()
+	}
+
 	log.Println("🚀 Server running on http://localhost:8080")
 	r.Run(":8080") // ← GinのみでListen
 }
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// セキュリティ上の出来事の記録（アカウントロックなど）
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Event     string    `gorm:"type:varchar(64);index;not null" json:"event"` // 例: "login_locked"
+	UserID    *uint     `gorm:"index" json:"user_id"`                         // 対象ユーザー（分からなければ nil）
+	Username  string    `gorm:"type:varchar(255)" json:"username"`
+	IP        string    `gorm:"type:varchar(64)" json:"ip"`
+	Detail    string    `gorm:"type:text" json:"detail"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
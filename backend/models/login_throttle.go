@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// ログイン失敗の回数（アカウントごと・IPアドレスごと）
+// Key は "user:<ユーザー名>" または "ip:<IPアドレス>"
+type LoginThrottle struct {
+	Key           string     `gorm:"type:varchar(255);primaryKey"`
+	Failures      int        `gorm:"not null;default:0"`
+	LastFailureAt time.Time  `gorm:"not null"`
+	LockedUntil   *time.Time // この時刻まではパスワードを確認せずに拒否する
+}
@@ -7,15 +7,17 @@ import (
 )
 
 type Message struct {
-	ID           uint                `json:"id"`
-	RoomID       uint                `gorm:"index;not null" json:"room_id"`
-	SenderID     uint                `gorm:"index;not null" json:"sender_id"`
-	Content      string              `gorm:"type:text"json:"content"`
-	ThreadRootID *uint               `gorm:"index"json:"thread_root_id"`
-	CreatedAt    time.Time           `json:"created_at"`
+	ID           uint                `gorm:"index:idx_messages_room_created,priority:3" json:"id"`
+	RoomID       uint                `gorm:"index;index:idx_messages_room_created,priority:1;not null" json:"room_id"` // (room_id, created_at, id) はページングの並び順
+	SenderID     uint                `gorm:"index;not null;uniqueIndex:idx_messages_sender_client_msg,priority:1" json:"sender_id"`
+	Content      string              `gorm:"type:text" json:"content"`
+	ThreadRootID *uint               `gorm:"index" json:"thread_root_id"`
+	CreatedAt    time.Time           `gorm:"index:idx_messages_room_created,priority:2" json:"created_at"`
 	SenderName   string              `gorm:"type:varchar(255)" json:"sender_name"`
 	Type         string              `json:"type"`
+	ClientMsgID  *string             `gorm:"type:varchar(64);uniqueIndex:idx_messages_sender_client_msg,priority:2" json:"client_msg_id"` // クライアント採番のID（再送時の重複防止）
 	Attachments  []MessageAttachment `gorm:"foreignKey:MessageID"`
+	EditedAt     *time.Time          `json:"edited_at"` // 最後に本文を編集した時刻（未編集なら null）
 	UpdatedAt    time.Time
 	DeletedAt    gorm.DeletedAt `gorm:"index"`
 }
@@ -7,7 +7,6 @@ import (
 type MessageRead struct {
 	MessageID uint      `gorm:"primaryKey"`
 	UserID    uint      `gorm:"primaryKey"`
-	Reaction  *string   `gorm:"type:varchar(255);"`
 	ReadAt    time.Time `gorm:"autoCreateTime"`
 }
 
@@ -15,6 +14,13 @@ type MessageWithRead struct {
 	Message        `json:",inline"`
 	IsRead         bool `json:"isRead"`         // 自分が読んだか
 	IsReadByOthers bool `json:"isReadByOthers"` // 他人が読んだか（送信者が確認）
+
+	// スレッドの情報（ルームのタイムラインの親メッセージだけ）
+	ReplyCount     int64      `json:"reply_count"`
+	LastReplyAt    *time.Time `json:"last_reply_at"`
+	ParticipantIDs []uint     `json:"participant_ids"` // 返信したユーザー（最初に返信した順）
+
+	Reactions []ReactionCount `json:"reactions"` // 絵文字ごとのリアクション数（最初に付いた順）
 }
 
 type ReadNotification struct {
@@ -26,12 +32,41 @@ type ReadNotification struct {
 
 // handlers/ws.go 内の上部（import文の下など）に追加
 type WSMessage struct {
-	Type        string              `json:"type"` // "message"
-	ID          uint                `json:"id"`
-	RoomID      uint                `json:"room_id"`
-	SenderID    uint                `json:"sender_id"`
-	SenderName  string              `json:"sender_name"`
-	Content     string              `json:"content"`
-	CreatedAt   string              `json:"created_at"` // RFC3339で送る用
-	Attachments []MessageAttachment `json:"attachments"`
+	Type         string              `json:"type"` // "message" / "thread_reply" / "typing" / "typing_stop"
+	ID           uint                `json:"id"`
+	ThreadRootID *uint               `json:"thread_root_id,omitempty"` // thread_reply のときの親メッセージ
+	RoomID       uint                `json:"room_id"`
+	SenderID     uint                `json:"sender_id"`
+	SenderName   string              `json:"sender_name"`
+	Content      string              `json:"content"`
+	CreatedAt    string              `json:"created_at"` // RFC3339で送る用
+	Attachments  []MessageAttachment `json:"attachments"`
+	ClientMsgID  *string             `json:"client_msg_id,omitempty"` // 送信者の楽観的表示との突き合わせ用
+}
+
+// クライアント → サーバーの WebSocket フレーム
+type WSClientFrame struct {
+	Type         string  `json:"type"` // "message"（省略時）/ "typing" / "heartbeat" / "subscribe" / "unsubscribe"
+	RoomID       uint    `json:"room_id"`
+	Content      string  `json:"content"`
+	SenderName   string  `json:"sender_name"`
+	ThreadRootID *uint   `json:"thread_root_id"`
+	ClientMsgID  *string `json:"client_msg_id"` // 再送時の重複防止用（任意）
+}
+
+// サーバー → クライアントのエラーフレーム
+type WSErrorFrame struct {
+	Type    string `json:"type"` // "error"
+	Code    string `json:"code"` // "not_a_member" / "invalid_frame" など
+	Message string `json:"message"`
+	RoomID  uint   `json:"room_id,omitempty"`
+}
+
+// 送信したメッセージの受領通知（client_msg_id → サーバー側ID）
+type WSAckFrame struct {
+	Type        string `json:"type"` // "ack"
+	ClientMsgID string `json:"client_msg_id"`
+	MessageID   uint   `json:"message_id"`
+	RoomID      uint   `json:"room_id"`
+	Duplicate   bool   `json:"duplicate"` // 既に保存済みだった場合 true
 }
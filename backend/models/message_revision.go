@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// メッセージの編集履歴（編集のたびに1行。編集前後の本文を残す）
+type MessageRevision struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	MessageID  uint      `gorm:"index;not null" json:"message_id"`
+	EditorID   uint      `gorm:"not null" json:"editor_id"`
+	OldContent string    `gorm:"type:text" json:"old_content"`
+	NewContent string    `gorm:"type:text" json:"new_content"`
+	CreatedAt  time.Time `json:"edited_at"` // 編集した時刻
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// パーソナルアクセストークン（スクリプト・ボットから API を呼ぶためのトークン）
+// 平文は作成時に一度だけ返し、DB には SHA-256 のハッシュだけを保存する
+type PersonalAccessToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"user_id"` // トークンで操作するユーザー（本人またはボット）
+	CreatedBy  uint       `gorm:"index;not null" json:"-"`       // トークンを発行したユーザー
+	Name       string     `gorm:"type:varchar(255);not null" json:"name"`
+	TokenHash  string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	Scopes     string     `gorm:"type:text;not null" json:"-"` // スペース区切り（例: "messages:write rooms:read"）
+	ExpiresAt  *time.Time `json:"expires_at"`                  // nil なら無期限
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// サーバーごとの在席状況（複数サーバー構成でも同じ答えを返すため DB で共有する）
+// ユーザーがどこかのサーバーに接続している間だけ行がある
+type Presence struct {
+	NodeID    string    `gorm:"type:varchar(64);primaryKey"` // 接続を持っているサーバー
+	UserID    uint      `gorm:"primaryKey;index"`
+	Status    string    `gorm:"type:varchar(16);not null"` // "online" / "away"
+	UpdatedAt time.Time `gorm:"index"`                     // サーバーが定期的に更新する（止まったサーバーの行は無視・削除される）
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// メッセージへの絵文字リアクション（1人が1つのメッセージに複数の絵文字を付けられる）
+type MessageReaction struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MessageID uint      `gorm:"not null;uniqueIndex:idx_message_reactions_unique,priority:1" json:"message_id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_message_reactions_unique,priority:2;index" json:"user_id"`
+	Emoji     string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_message_reactions_unique,priority:3" json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// メッセージ一覧に付けるリアクションの集計
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int64  `json:"count"`
+	Me    bool   `json:"me"` // 自分も付けているか
+}
+
+// リアクションの追加・削除の通知（WebSocket / SSE）
+type ReactionNotification struct {
+	Type      string `json:"type"`   // "reaction"
+	Action    string `json:"action"` // "added" / "removed"
+	MessageID uint   `json:"message_id"`
+	RoomID    uint   `json:"room_id"`
+	UserID    uint   `json:"user_id"`
+	Emoji     string `json:"emoji"`
+	Count     int64  `json:"count"` // 変更後のその絵文字の数
+}
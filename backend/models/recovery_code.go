@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// 二要素認証のリカバリーコード（認証アプリを失くしたとき用、1回限り）
+// 平文は有効化時に一度だけ返し、DB には SHA-256 のハッシュだけを保存する
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index;not null"`
+	CodeHash  string     `gorm:"type:varchar(64);not null"`
+	UsedAt    *time.Time // 使用済みになった時刻
+	CreatedAt time.Time
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// リフレッシュトークン（平文は保存せず SHA-256 のハッシュだけを持つ）
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index;not null"`
+	FamilyID  string     `gorm:"type:varchar(64);index;not null"`       // 同じログインから続くトークンの系列
+	TokenHash string     `gorm:"type:varchar(64);uniqueIndex;not null"` // SHA-256（16進）
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // ローテーションで使用済みになった時刻
+	RevokedAt *time.Time // 失効させた時刻（再利用検知・ログアウトなど）
+	CreatedAt time.Time
+}
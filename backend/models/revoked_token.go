@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// 失効させたアクセストークン（jti 単位、またはセッション単位）
+// アクセストークンの有効期限が過ぎたら不要になるので削除してよい
+type RevokedToken struct {
+	ID        uint      `gorm:"primaryKey"`
+	JTI       string    `gorm:"type:varchar(64);index"` // 特定のトークンを失効
+	SessionID string    `gorm:"type:varchar(64);index"` // セッション（リフレッシュトークンの系列）ごと失効
+	UserID    uint      `gorm:"index;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
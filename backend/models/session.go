@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// ログインセッション（1回のログイン = 1セッション）
+// ID はアクセストークンの sid、リフレッシュトークンの family_id と同じ値
+type Session struct {
+	ID         string     `gorm:"type:varchar(64);primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"-"`
+	Device     string     `gorm:"type:varchar(255)" json:"device"`
+	IP         string     `gorm:"type:varchar(64)" json:"ip"`
+	UserAgent  string     `gorm:"type:text" json:"user_agent"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"-"` // ログアウト・強制終了した時刻
+}
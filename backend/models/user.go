@@ -1,15 +1,40 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type User struct {
 	gorm.Model
-	Username        string `gorm:"uniqueIndex;not null" json:"username"`
-	Email           string
-	PasswordHash    string `json:"password_hash"`
+	Username        string     `gorm:"uniqueIndex;not null" json:"username"`
+	Email           string     `gorm:"uniqueIndex:idx_users_email,where:email <> ''" json:"-"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"` // メールアドレスを確認した時刻（未確認なら nil）
+	PasswordHash    string     `json:"-"`
 	ProfileImageURL string
 	ProfileMessage  string
 	IsAdmin         bool
+	LastSeenAt      *time.Time `json:"last_seen_at"` // 最後にWebSocketで操作・接続していた時刻
+
+	// ボット（API 自動化用のユーザー。パスワードでのログインはできず、アクセストークンだけで使う）
+	IsBot   bool  `json:"is_bot"`
+	OwnerID *uint `gorm:"index" json:"owner_id,omitempty"` // ボットを作成したユーザー
+
+	// シングルサインオン（OIDC）で作られたユーザーの IdP 上の識別子（ローカルユーザーは空）
+	OIDCIssuer  string `gorm:"uniqueIndex:idx_users_oidc,where:oidc_subject <> ''" json:"-"`
+	OIDCSubject string `gorm:"uniqueIndex:idx_users_oidc,where:oidc_subject <> ''" json:"-"`
+
+	// 二要素認証（TOTP）
+	TOTPSecret   string `json:"-"`            // base32 の共有鍵（設定中も保存し、確認後に有効化）
+	TOTPEnabled  bool   `json:"totp_enabled"` // true ならログインにコードが必要
+	TOTPLastStep int64  `json:"-"`            // 最後に使われたコードの時間ステップ（同じコードの再利用防止）
+}
+
+// 在席状況（GET /users/presence のレスポンス、WebSocket の presence イベント）
+type PresenceNotification struct {
+	Type       string     `json:"type"` // "presence"
+	UserID     uint       `json:"user_id"`
+	Status     string     `json:"status"` // "online" / "away" / "offline"
+	LastSeenAt *time.Time `json:"last_seen_at"`
 }
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// 機密情報は JSON に含めない（ユーザーを返すすべての API に関わる）
+func TestUserJSONOmitsSecrets(t *testing.T) {
+	now := time.Now()
+	user := User{
+		Username:        "alice",
+		Email:           "alice@example.com",
+		EmailVerifiedAt: &now,
+		PasswordHash:    "$2a$10$hash",
+		OIDCIssuer:      "https://idp.example.com",
+		OIDCSubject:     "sub-1",
+		TOTPSecret:      "JBSWY3DPEHPK3PXP",
+		TOTPEnabled:     true,
+		TOTPLastStep:    123,
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	json.Unmarshal(data, &got)
+
+	tests := []struct {
+		key  string
+		want bool // JSON に含まれるか
+	}{
+		{"username", true},
+		{"email_verified_at", true},
+		{"totp_enabled", true},
+		{"email", false},
+		{"Email", false},
+		{"PasswordHash", false},
+		{"OIDCIssuer", false},
+		{"OIDCSubject", false},
+		{"TOTPSecret", false},
+		{"TOTPLastStep", false},
+	}
+	for _, tt := range tests {
+		if _, ok := got[tt.key]; ok != tt.want {
+			t.Errorf("%s present = %v, want %v", tt.key, ok, tt.want)
+		}
+	}
+}
@@ -219,6 +219,7 @@ useEffect(() => {
                   {/* 相手の送信時間 */}
                   {!isMine && (
                     <div style={{ fontSize: 10, color: "#999", alignSelf: "flex-end" }}>
+                      {msg.edited_at && "(編集済み) "}
                       {dayjs(msg.created_at).format("HH:mm")}
                     </div>
                   )}
@@ -351,6 +352,7 @@ useEffect(() => {
                   {/* 自分の送信時間 */}
                   {isMine && (
                     <div style={{ fontSize: 10, color: "#999", alignSelf: "flex-end" }}>
+                      {msg.edited_at && "(編集済み) "}
                       {dayjs(msg.created_at).format("HH:mm")}
                     </div>
                   )}
@@ -3,12 +3,12 @@
 
 // ユーザーの新規登録（サインアップ）処理
 // 使用される場所: Signupページ（例: pages/signup.tsx）
-// 使用例: signup(username, password)
-export async function signup(username: string, password: string): Promise<void> {
+// 使用例: signup(username, password, email)
+export async function signup(username: string, password: string, email: string): Promise<void> {
   const res = await fetch("http://localhost:8080/signup", {
     method: "POST", // POSTメソッドでサインアップAPIを呼び出す
     headers: { "Content-Type": "application/json" }, // リクエストヘッダーにJSON形式を指定
-    body: JSON.stringify({ username, password }) // ユーザー名とパスワードをJSONとして送信
+    body: JSON.stringify({ username, password, email }) // ユーザー名・パスワード・メールアドレスをJSONとして送信
   });
 
   if (!res.ok) {
@@ -29,14 +29,111 @@ export async function login(username: string, password: string): Promise<string>
 
   const data = await res.json(); // レスポンスをJSONとして取得
 
+  // 二要素認証が有効なアカウント → コード入力が必要（verifyLoginCode へ）
+  if (res.ok && data.two_factor_required) {
+    throw new TwoFactorRequiredError(data.challenge_token);
+  }
+
+  return handleTokenResponse(res, data);
+}
+
+// 二要素認証が必要なときに login() が投げるエラー
+export class TwoFactorRequiredError extends Error {
+  constructor(public challengeToken: string) {
+    super("二要素認証コードを入力してください");
+  }
+}
+
+// ログインの2段階目（認証アプリのコード、またはリカバリーコードを送る）
+// 使用される場所: Loginページ（例: pages/login.tsx）
+// 使用例: const token = await verifyLoginCode(challengeToken, "123456")
+export async function verifyLoginCode(challengeToken: string, code: string): Promise<string> {
+  // 6桁の数字以外はリカバリーコードとして送る
+  const body = /^\d{6}$/.test(code)
+    ? { challenge_token: challengeToken, code }
+    : { challenge_token: challengeToken, recovery_code: code };
+
+  const res = await fetch("http://localhost:8080/login/2fa", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(body),
+  });
+
+  return handleTokenResponse(res, await res.json());
+}
+
+// ログイン成功レスポンスからトークンを取り出す（/login と /login/2fa で共通）
+function handleTokenResponse(res: Response, data: any): string {
   // ステータスがOKでない、またはトークンが含まれていない場合はエラーをスロー
   if (!res.ok || !data.token) {
     throw new Error(data.error || "ログインに失敗しました");
   }
 
+  // リフレッシュトークンは保存しておき、アクセストークンの期限前に更新する
+  if (data.refresh_token) {
+    localStorage.setItem("refresh_token", data.refresh_token);
+  }
+
   return data.token; // トークンを返却（ログイン成功）
 }
 
+// アクセストークンの更新処理（リフレッシュトークンは使うたびに新しいものに入れ替わる）
+// 使用される場所: pages/_app.tsx（定期実行）
+// 使用例: await refreshAccessToken()
+export async function refreshAccessToken(): Promise<string | null> {
+  const refreshToken = localStorage.getItem("refresh_token");
+  if (!refreshToken) return null;
+
+  const res = await fetch("http://localhost:8080/auth/refresh", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ refresh_token: refreshToken }),
+  });
+
+  if (!res.ok) {
+    // 失効・再利用検知など → ログアウト扱い
+    logout();
+    return null;
+  }
+
+  const data = await res.json();
+  localStorage.setItem("token", data.token);
+  localStorage.setItem("refresh_token", data.refresh_token);
+  return data.token;
+}
+
+// メールアドレスの確認（確認メールのリンクに含まれるトークンを送る）
+// 使用される場所: pages/verify-email.tsx
+export async function verifyEmail(token: string): Promise<void> {
+  await postJSON("http://localhost:8080/email/verify", { token }, "メールアドレスの確認に失敗しました");
+}
+
+// パスワード再設定メールの送信を依頼する
+// 使用される場所: pages/reset-password.tsx
+export async function requestPasswordReset(email: string): Promise<void> {
+  await postJSON("http://localhost:8080/password/forgot", { email }, "送信に失敗しました");
+}
+
+// 新しいパスワードを設定する（再設定メールのリンクに含まれるトークンを送る）
+// 使用される場所: pages/reset-password.tsx
+export async function resetPassword(token: string, password: string): Promise<void> {
+  await postJSON("http://localhost:8080/password/reset", { token, password }, "パスワードの再設定に失敗しました");
+}
+
+// 認証不要の API に JSON を POST し、失敗したらエラーをスローする
+async function postJSON(url: string, body: object, fallbackError: string) {
+  const res = await fetch(url, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(body),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(data.error || fallbackError);
+  }
+  return data;
+}
+
 // 現在ログイン中のユーザー情報を取得する関数
 // 使用される場所: 
 // - App全体の初期化処理（例: pages/_app.tsx や context/AuthContext.tsx）
@@ -51,11 +148,20 @@ export async function fetchMe(token: string) {
   return res.json(); // ユーザー情報をJSON形式で返却
 }
 
-// ログアウト処理：ローカルストレージからトークンを削除
+// ログアウト処理：サーバー側でセッションを失効させ、ローカルストレージからトークンを削除
 // 使用される場所: 
 // - ナビゲーションバー（例: components/Navbar.tsx）
 // - 設定画面やユーザーメニュー（例: pages/settings.tsx）
 // 使用例: logout()
 export function logout() {
+  const token = localStorage.getItem("token");
+  if (token) {
+    // サーバー側でもトークンを失効させる（結果は待たない）
+    fetch("http://localhost:8080/logout", {
+      method: "POST",
+      headers: { Authorization: `Bearer ${token}` },
+    }).catch((err) => console.error("ログアウト通知失敗:", err));
+  }
   localStorage.removeItem("token"); // 保存されているトークンを削除
+  localStorage.removeItem("refresh_token");
 }
@@ -2,33 +2,109 @@
 // メッセージ取得・送信に関するAPIユーティリティ関数群
 // 使用される主な場所: チャット画面（例: pages/chat/[room_id].tsx や components/ChatWindow.tsx）
 
-import { Message } from "../types";
+import { Message, MessageRevision, Reaction } from "../types";
+
+// =========================
+// 🔹 メッセージ一覧のページ
+// =========================
+// - messages は古い順
+// - nextBefore / nextAfter はその方向にまだメッセージがあるときだけ入る
+export type MessagePage = {
+  messages: Message[];
+  hasMore: boolean;
+  nextBefore: number | null;
+  nextAfter: number | null;
+};
+
+// ページングの指定（before / after / around はどれか1つ）
+export type MessageCursor = {
+  before?: number;
+  after?: number;
+  around?: number;
+  limit?: number;
+};
+
+// カーソルをクエリ文字列にする
+function cursorQuery(cursor: MessageCursor): string {
+  const params = new URLSearchParams();
+  Object.entries(cursor).forEach(([key, value]) => {
+    if (value != null) params.set(key, String(value));
+  });
+  return params.toString();
+}
+
+// レスポンスをページに整形する
+function toMessagePage(data: any): MessagePage {
+  return {
+    // ✅ isRead が存在しないときは false に補正（保険）
+    messages: (data.messages ?? []).map((msg: Partial<Message> & { isRead?: boolean }) => ({
+      ...msg,
+      isRead: msg.isRead ?? false,
+    })),
+    hasMore: data.has_more ?? false,
+    nextBefore: data.next_before ?? null,
+    nextAfter: data.next_after ?? null,
+  };
+}
+
+// =========================
+// 🔹 指定されたルームのメッセージを1ページ取得
+// =========================
+// - 処理: カーソルを指定して古いメッセージをさかのぼる（before）、新着を追う（after）、特定メッセージの前後を開く（around）
+// - エンドポイント: GET /messages?room_id=xxx&before=123&limit=50
+// - 使用例: const page = await fetchMessagePage(token, roomId, { before: page.nextBefore });
+export async function fetchMessagePage(
+  token: string,
+  roomId: number,
+  cursor: MessageCursor = {}
+): Promise<MessagePage> {
+  const q = cursorQuery(cursor);
+  const res = await fetch(`http://localhost:8080/messages?room_id=${roomId}${q ? "&" + q : ""}`, {
+    headers: {
+      Authorization: `Bearer ${token}`, // JWTトークンをヘッダーに添付（認証）
+    },
+  });
+
+  if (!res.ok) throw new Error("メッセージ取得に失敗しました");
+
+  return toMessagePage(await res.json());
+}
 
 // =========================
 // 🔹 指定されたルームのメッセージ一覧を取得
 // =========================
-// - 処理: 特定のチャットルームに属する全メッセージを取得する
+// - 処理: 特定のチャットルームの最新メッセージ（1ページ分）を取得する
 // - エンドポイント: GET /messages?room_id=xxx
 // - 使用場所:
 //   - チャット画面を開いたときの初期表示（例: ChatWindow.tsx や MessageList.tsx）
 //   - ルーム切り替え時にも呼び出される
 // - 使用例: const messages = await fetchMessages(token, roomId);
 export async function fetchMessages(token: string, roomId: number): Promise<Message[]> {
-  const res = await fetch(`http://localhost:8080/messages?room_id=${roomId}`, {
-    headers: {
-      Authorization: `Bearer ${token}`, // JWTトークンをヘッダーに添付（認証）
-    },
-  });
+  const page = await fetchMessagePage(token, roomId);
+  return page.messages;
+}
 
-  if (!res.ok) throw new Error("メッセージ取得に失敗しました");
+// =========================
+// 🔹 スレッド（親メッセージと返信）を取得
+// =========================
+// - 処理: 親メッセージと、その返信を1ページ分取得する（返信は古い順）
+// - エンドポイント: GET /messages/:id/thread
+// - 新しい返信は WebSocket の thread_reply イベントで届く
+// - 使用例: const { root, messages } = await fetchThread(token, rootId);
+export async function fetchThread(
+  token: string,
+  rootId: number,
+  cursor: MessageCursor = {}
+): Promise<MessagePage & { root: Message }> {
+  const q = cursorQuery(cursor);
+  const res = await fetch(`http://localhost:8080/messages/${rootId}/thread${q ? "?" + q : ""}`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
 
-  const data = await res.json(); // メッセージの配列を返す
+  if (!res.ok) throw new Error("スレッド取得に失敗しました");
 
-  // ✅ isRead が存在しないときは false に補正（保険）
-  return data.map((msg: Partial<Message> & { isRead?: boolean }) => ({
-    ...msg,
-    isRead: msg.isRead ?? false,
-  }));  
+  const data = await res.json();
+  return { ...toMessagePage(data), root: data.root };
 }
 
 // =========================
@@ -59,20 +135,24 @@ export async function sendMessage(
 }
 
 
-export async function fetchGroupMessages(token: string, roomId: number): Promise<Message[]> {
-  const res = await fetch(`http://localhost:8080/messages/group/${roomId}`, {
+export async function fetchGroupMessagePage(
+  token: string,
+  roomId: number,
+  cursor: MessageCursor = {}
+): Promise<MessagePage> {
+  const q = cursorQuery(cursor);
+  const res = await fetch(`http://localhost:8080/messages/group?room_id=${roomId}${q ? "&" + q : ""}`, {
     headers: { Authorization: `Bearer ${token}` },
   });
 
   if (!res.ok) throw new Error("メッセージ取得失敗");
 
-  const data = await res.json(); // メッセージの配列を返す
+  return toMessagePage(await res.json());
+}
 
-  // ✅ isRead が存在しないときは false に補正（保険）
-  return data.map((msg: any) => ({
-    ...msg,
-    isRead: msg.isRead ?? false,
-  }));
+export async function fetchGroupMessages(token: string, roomId: number): Promise<Message[]> {
+  const page = await fetchGroupMessagePage(token, roomId);
+  return page.messages;
 }
 
 
@@ -122,6 +202,22 @@ export async function updateMessage(id: number, content: string) {
   }
 }
 
+// =========================
+// 🔹 メッセージの編集履歴を取得
+// =========================
+// - エンドポイント: GET /messages/:id/history
+// - 戻り値: 編集ごとの履歴（古い順、編集者・時刻・編集前後の本文）
+export async function fetchMessageHistory(token: string, id: number): Promise<MessageRevision[]> {
+  const res = await fetch(`http://localhost:8080/messages/${id}/history`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+
+  if (!res.ok) throw new Error("編集履歴の取得に失敗しました");
+
+  const data = await res.json();
+  return data.revisions ?? [];
+}
+
 export async function deleteMessage(id: number) {
   const token = localStorage.getItem("token");
   const res = await fetch(`http://localhost:8080/messages/${id}`, {
@@ -136,3 +232,74 @@ export async function deleteMessage(id: number) {
   }
 }
 
+
+// =========================
+// 🔹 メッセージ検索
+// =========================
+// - 処理: 参加しているルームのメッセージを検索する（新しい順）
+// - エンドポイント: GET /search/messages?q=xxx
+// - snippet は HTML エスケープ済みで、一致箇所が <mark> で囲まれている
+// - 使用例: const { results } = await searchMessages(token, { q: "会議 資料", roomId: 3 });
+export type MessageSearchParams = {
+  q: string;
+  roomId?: number;
+  senderId?: number;
+  from?: string;          // YYYY-MM-DD または ISO 文字列
+  to?: string;
+  hasAttachment?: boolean;
+  before?: number;        // 続きを取るときは前回の nextBefore
+  limit?: number;
+};
+
+export type MessageSearchResult = Message & { snippet: string };
+
+export async function searchMessages(
+  token: string,
+  params: MessageSearchParams
+): Promise<{ results: MessageSearchResult[]; hasMore: boolean; nextBefore: number | null }> {
+  const query = new URLSearchParams({ q: params.q });
+  if (params.roomId != null) query.set("room_id", String(params.roomId));
+  if (params.senderId != null) query.set("sender_id", String(params.senderId));
+  if (params.from) query.set("from", params.from);
+  if (params.to) query.set("to", params.to);
+  if (params.hasAttachment != null) query.set("has_attachment", String(params.hasAttachment));
+  if (params.before != null) query.set("before", String(params.before));
+  if (params.limit != null) query.set("limit", String(params.limit));
+
+  const res = await fetch(`http://localhost:8080/search/messages?${query}`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+
+  if (!res.ok) throw new Error("メッセージ検索に失敗しました");
+
+  const data = await res.json();
+  return {
+    results: data.results ?? [],
+    hasMore: data.has_more ?? false,
+    nextBefore: data.next_before ?? null,
+  };
+}
+
+// =========================
+// 🔹 リアクションを付ける・外す
+// =========================
+// - エンドポイント: POST / DELETE /messages/:id/reactions/:emoji
+// - 変更はルームの全員に WebSocket の reaction イベントで届く
+// - 戻り値: そのメッセージのリアクション集計
+// - 使用例: const reactions = await toggleReaction(token, msg.id, "👍", true);
+export async function toggleReaction(
+  token: string,
+  messageId: number,
+  emoji: string,
+  add: boolean
+): Promise<Reaction[]> {
+  const res = await fetch(`http://localhost:8080/messages/${messageId}/reactions/${encodeURIComponent(emoji)}`, {
+    method: add ? "POST" : "DELETE",
+    headers: { Authorization: `Bearer ${token}` },
+  });
+
+  if (!res.ok) throw new Error("リアクションの更新に失敗しました");
+
+  const data = await res.json();
+  return data.reactions ?? [];
+}
@@ -1,7 +1,20 @@
 import "@/styles/globals.css";  // アプリ全体にCSSを読み込む
 import type { AppProps } from "next/app";
+import { useEffect } from "react";
+import { refreshAccessToken } from "../lib/auth";
+
+// アクセストークンの更新間隔（サーバー側の有効期限 15 分より短く）
+const REFRESH_INTERVAL_MS = 10 * 60 * 1000;
 
 // 全ページの共通ラッパー
 export default function App({ Component, pageProps }: AppProps) {
+  // ログイン中はアクセストークンを定期的に更新する
+  useEffect(() => {
+    const timer = setInterval(() => {
+      refreshAccessToken().catch((err) => console.error("トークン更新失敗:", err));
+    }, REFRESH_INTERVAL_MS);
+    return () => clearInterval(timer);
+  }, []);
+
   return <Component {...pageProps} />;
 }
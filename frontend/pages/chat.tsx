@@ -128,7 +128,8 @@ export default function ChatPage() {
       return;
     }
   
-    const ws = new WebSocket(`ws://localhost:8080/ws?token=${token}&room_id=${roomId}`);
+    // 所属ルームはサーバー側でまとめて購読される
+    const ws = new WebSocket(`ws://localhost:8080/ws?token=${token}`);
 
     socketRef.current = ws;
   
@@ -138,6 +139,9 @@ export default function ChatPage() {
     ws.onmessage = (event) => {
       const data = JSON.parse(event.data);
       console.log("📥 WS受信:", data);
+
+      // 表示中以外のルームのイベントは無視
+      if (data.room_id != null && Number(data.room_id) !== roomId) return;
     
       if (data.type === "message") {
         if (!data.hasOwnProperty("isReadByOthers")) {
@@ -167,7 +171,7 @@ export default function ChatPage() {
       } else if (data.type === "update") {
         setMessages((prev) =>
           prev.map((msg) =>
-            msg.id === data.message_id ? { ...msg, content: data.new_content } : msg
+            msg.id === data.message_id ? { ...msg, content: data.new_content, edited_at: data.edited_at } : msg
           )
         );
     
@@ -314,6 +318,8 @@ useEffect(() => {
         created_at: new Date().toISOString(),
         sender_name: me.username,
         sender_id: me.id,
+        // WebSocket と REST の両方で送っても1件だけ保存されるように同じIDを付ける
+        client_msg_id: crypto.randomUUID(),
       };
   
       if (ws instanceof WebSocket && ws.readyState === WebSocket.OPEN) {
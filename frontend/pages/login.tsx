@@ -4,13 +4,16 @@
 
 import { useState } from 'react';
 import { useRouter } from 'next/router';
-import { login } from '../lib/auth'; // 🔸 認証API呼び出し関数（POST /login）
+import { login, verifyLoginCode, TwoFactorRequiredError } from '../lib/auth'; // 🔸 認証API呼び出し関数（POST /login, /login/2fa）
 
 export default function LoginPage() {
   // ユーザー名、パスワード、エラーメッセージの状態を管理
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
   const [error, setError] = useState('');
+  // 二要素認証が必要なときのチャレンジトークンと入力コード
+  const [challengeToken, setChallengeToken] = useState('');
+  const [code, setCode] = useState('');
 
   // Next.js のルーター（リダイレクト用）
   const router = useRouter();
@@ -20,8 +23,10 @@ export default function LoginPage() {
     e.preventDefault(); // ページリロードを防止
 
     try {
-      // 🔸 認証APIを呼び出してトークン取得
-      const token = await login(username, password);
+      // 🔸 認証APIを呼び出してトークン取得（2FA 入力中ならコードを送る）
+      const token = challengeToken
+        ? await verifyLoginCode(challengeToken, code)
+        : await login(username, password);
 
       // 🔸 トークンをローカルストレージに保存（ログイン状態を保持）
       localStorage.setItem('token', token);
@@ -29,6 +34,12 @@ export default function LoginPage() {
       // 🔸 チャット画面へ遷移
       router.push('/chat');
     } catch (err: any) {
+      // 🔸 二要素認証が必要 → コード入力欄を表示
+      if (err instanceof TwoFactorRequiredError) {
+        setChallengeToken(err.challengeToken);
+        setError('');
+        return;
+      }
       // 🔸 エラーがあれば表示
       setError(err.message || 'ログインに失敗しました');
     }
@@ -87,6 +98,27 @@ export default function LoginPage() {
           />
         </div>
 
+        {/* 🔸 二要素認証コード入力欄（2FA が有効なアカウントのみ） */}
+        {challengeToken && (
+          <div style={{ marginBottom: 20 }}>
+            <label style={{ display: 'block', marginBottom: 4 }}>認証コード（またはリカバリーコード）</label>
+            <input
+              type="text"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              autoComplete="one-time-code"
+              required
+              style={{
+                width: '100%',
+                padding: 10,
+                border: '1px solid #ccc',
+                borderRadius: 4,
+                fontSize: 14,
+              }}
+            />
+          </div>
+        )}
+
         {/* 🔸 送信ボタン */}
         <button
           type="submit"
@@ -104,6 +136,28 @@ export default function LoginPage() {
           ログイン
         </button>
 
+        {/* 🔸 シングルサインオン（バックエンドが IdP へリダイレクトする） */}
+        <a
+          href="http://localhost:8080/auth/oidc/login"
+          style={{
+            display: 'block',
+            textAlign: 'center',
+            marginTop: 12,
+            padding: 10,
+            border: '1px solid #007bff',
+            borderRadius: 4,
+            color: '#007bff',
+            textDecoration: 'none',
+          }}
+        >
+          社内アカウントでログイン
+        </a>
+
+        {/* 🔸 パスワード再設定へのリンク */}
+        <p style={{ textAlign: 'center', marginTop: 12, fontSize: 14 }}>
+          <a href="/reset-password">パスワードを忘れた方</a>
+        </p>
+
         {/* 🔸 エラーメッセージの表示 */}
         {error && (
           <p style={{ color: 'red', textAlign: 'center', marginTop: 12 }}>{error}</p>
@@ -0,0 +1,116 @@
+// pages/oidc-callback.tsx（シングルサインオンの戻り先ページ）
+// - バックエンドの /auth/oidc/callback からリダイレクトされる
+// - 結果は URL のフラグメント（#token=...&refresh_token=... または #challenge_token=... / #error=...）で受け取る
+// - 使用している関数: `verifyLoginCode()`（lib/auth.ts からインポート）
+
+import { useEffect, useState } from 'react';
+import { useRouter } from 'next/router';
+import { verifyLoginCode } from '../lib/auth'; // 🔸 POST /login/2fa
+
+export default function OIDCCallbackPage() {
+  const router = useRouter();
+  const [error, setError] = useState('');
+  // 二要素認証が必要なときのチャレンジトークンと入力コード
+  const [challengeToken, setChallengeToken] = useState('');
+  const [code, setCode] = useState('');
+
+  // 🔹 フラグメントからトークンを取り出す（履歴に残らないようすぐに消す）
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    window.history.replaceState(null, '', window.location.pathname);
+
+    if (params.get('error')) {
+      setError(params.get('error') || 'シングルサインオンに失敗しました');
+      return;
+    }
+    if (params.get('challenge_token')) {
+      setChallengeToken(params.get('challenge_token') || '');
+      return;
+    }
+
+    const token = params.get('token');
+    if (!token) {
+      setError('シングルサインオンに失敗しました');
+      return;
+    }
+    localStorage.setItem('token', token);
+    localStorage.setItem('refresh_token', params.get('refresh_token') || '');
+    router.replace('/chat');
+  }, []);
+
+  // 🔹 二要素認証コードの送信
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    try {
+      const token = await verifyLoginCode(challengeToken, code);
+      localStorage.setItem('token', token);
+      router.replace('/chat');
+    } catch (err: any) {
+      setError(err.message || 'ログインに失敗しました');
+    }
+  };
+
+  return (
+    <div
+      style={{
+        maxWidth: 400,
+        margin: '100px auto',
+        padding: 30,
+        border: '1px solid #ccc',
+        borderRadius: 8,
+        boxShadow: '0 4px 10px rgba(0,0,0,0.1)',
+        backgroundColor: '#fff',
+        textAlign: 'center',
+      }}
+    >
+      <h2 style={{ marginBottom: 20 }}>シングルサインオン</h2>
+
+      {challengeToken ? (
+        <form onSubmit={handleSubmit}>
+          <label style={{ display: 'block', marginBottom: 4 }}>認証コード（またはリカバリーコード）</label>
+          <input
+            type="text"
+            value={code}
+            onChange={(e) => setCode(e.target.value)}
+            autoComplete="one-time-code"
+            required
+            style={{
+              width: '100%',
+              padding: 10,
+              border: '1px solid #ccc',
+              borderRadius: 4,
+              fontSize: 14,
+              marginBottom: 16,
+            }}
+          />
+          <button
+            type="submit"
+            style={{
+              width: '100%',
+              padding: 12,
+              backgroundColor: '#007bff',
+              color: '#fff',
+              border: 'none',
+              borderRadius: 4,
+              fontSize: 16,
+              cursor: 'pointer',
+            }}
+          >
+            ログイン
+          </button>
+        </form>
+      ) : (
+        !error && <p>ログイン中...</p>
+      )}
+
+      {error && (
+        <>
+          <p style={{ color: 'red', marginTop: 12 }}>{error}</p>
+          <p style={{ marginTop: 20 }}>
+            <a href="/login">ログイン画面へ</a>
+          </p>
+        </>
+      )}
+    </div>
+  );
+}
@@ -0,0 +1,91 @@
+// pages/reset-password.tsx（パスワード再設定ページ）
+// - token なし: メールアドレスを入力して再設定メールを送る
+// - token あり（再設定メールのリンクから開いた場合）: 新しいパスワードを設定する
+// - 使用している関数: `requestPasswordReset()`, `resetPassword()`（lib/auth.ts からインポート）
+
+import { useState } from 'react';
+import { useRouter } from 'next/router';
+import { requestPasswordReset, resetPassword } from '../lib/auth'; // 🔸 POST /password/forgot, /password/reset
+
+export default function ResetPasswordPage() {
+  const router = useRouter();
+  const token = typeof router.query.token === 'string' ? router.query.token : '';
+
+  const [email, setEmail] = useState('');
+  const [password, setPassword] = useState('');
+  const [message, setMessage] = useState('');
+  const [error, setError] = useState('');
+
+  // 🔹 フォーム送信時の処理
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError('');
+    try {
+      if (token) {
+        await resetPassword(token, password);
+        alert('パスワードを再設定しました。ログインしてください。');
+        router.push('/login');
+      } else {
+        await requestPasswordReset(email);
+        setMessage('登録されているメールアドレスであれば、再設定用のリンクを送信しました。');
+      }
+    } catch (err: any) {
+      setError(err.message || '処理に失敗しました');
+    }
+  };
+
+  return (
+    <div
+      style={{
+        maxWidth: 400,
+        margin: '100px auto',
+        padding: 30,
+        border: '1px solid #ccc',
+        borderRadius: 8,
+        boxShadow: '0 4px 10px rgba(0,0,0,0.1)',
+        backgroundColor: '#fff',
+      }}
+    >
+      <h2 style={{ textAlign: 'center', marginBottom: 20 }}>パスワードの再設定</h2>
+      <form onSubmit={handleSubmit}>
+        <div style={{ marginBottom: 20 }}>
+          <label style={{ display: 'block', marginBottom: 4 }}>
+            {token ? '新しいパスワード' : 'メールアドレス'}
+          </label>
+          <input
+            type={token ? 'password' : 'email'}
+            value={token ? password : email}
+            onChange={(e) => (token ? setPassword(e.target.value) : setEmail(e.target.value))}
+            required
+            style={{
+              width: '100%',
+              padding: 10,
+              border: '1px solid #ccc',
+              borderRadius: 4,
+              fontSize: 14,
+            }}
+          />
+        </div>
+        <button
+          type="submit"
+          style={{
+            width: '100%',
+            padding: 12,
+            backgroundColor: '#007bff',
+            color: '#fff',
+            border: 'none',
+            borderRadius: 4,
+            fontSize: 16,
+            cursor: 'pointer',
+          }}
+        >
+          {token ? '再設定する' : '再設定メールを送信'}
+        </button>
+        {message && <p style={{ textAlign: 'center', marginTop: 12 }}>{message}</p>}
+        {error && (
+          <p style={{ color: 'red', textAlign: 'center', marginTop: 12 }}>{error}</p>
+        )}
+      </form>
+    </div>
+  );
+}
@@ -6,14 +6,15 @@ import { signup } from '../lib/auth';
 export default function SignupPage() {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
+  const [email, setEmail] = useState('');
   const [error, setError] = useState('');
   const router = useRouter();
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
     try {
-      await signup(username, password);
-      alert('サインアップ成功！ログインしてください。');
+      await signup(username, password, email);
+      alert('サインアップ成功！確認メールのリンクを開いてから、ログインしてください。');
       router.push('/login');
     } catch (err: any) {
       setError(err.message || 'サインアップに失敗しました');
@@ -50,6 +51,22 @@ export default function SignupPage() {
             }}
           />
         </div>
+        <div style={{ marginBottom: 16 }}>
+          <label style={{ display: 'block', marginBottom: 4 }}>メールアドレス</label>
+          <input
+            type="email"
+            value={email}
+            onChange={(e) => setEmail(e.target.value)}
+            required
+            style={{
+              width: '100%',
+              padding: 10,
+              border: '1px solid #ccc',
+              borderRadius: 4,
+              fontSize: 14,
+            }}
+          />
+        </div>
         <div style={{ marginBottom: 20 }}>
           <label style={{ display: 'block', marginBottom: 4 }}>パスワード</label>
           <input
@@ -65,6 +82,9 @@ export default function SignupPage() {
               fontSize: 14,
             }}
           />
+          <p style={{ fontSize: 12, color: '#666', marginTop: 4 }}>
+            8文字以上で、英小文字・英大文字・数字・記号のうち2種類以上を含めてください
+          </p>
         </div>
         <button
           type="submit"
@@ -0,0 +1,48 @@
+// pages/verify-email.tsx（メールアドレス確認ページ）
+// - 確認メールのリンク（/verify-email?token=xxx）から開かれる
+// - 使用している関数: `verifyEmail()`（lib/auth.ts からインポート）
+
+import { useEffect, useState } from 'react';
+import { useRouter } from 'next/router';
+import { verifyEmail } from '../lib/auth'; // 🔸 POST /email/verify
+
+export default function VerifyEmailPage() {
+  const router = useRouter();
+  const [message, setMessage] = useState('確認中...');
+  const [error, setError] = useState('');
+
+  // 🔹 ページを開いたらトークンを送って確認する
+  useEffect(() => {
+    if (!router.isReady) return;
+    const token = router.query.token;
+    if (typeof token !== 'string') {
+      setError('確認リンクが正しくありません');
+      return;
+    }
+
+    verifyEmail(token)
+      .then(() => setMessage('メールアドレスを確認しました。ログインしてください。'))
+      .catch((err) => setError(err.message));
+  }, [router.isReady, router.query.token]);
+
+  return (
+    <div
+      style={{
+        maxWidth: 400,
+        margin: '100px auto',
+        padding: 30,
+        border: '1px solid #ccc',
+        borderRadius: 8,
+        boxShadow: '0 4px 10px rgba(0,0,0,0.1)',
+        backgroundColor: '#fff',
+        textAlign: 'center',
+      }}
+    >
+      <h2 style={{ marginBottom: 20 }}>メールアドレスの確認</h2>
+      {error ? <p style={{ color: 'red' }}>{error}</p> : <p>{message}</p>}
+      <p style={{ marginTop: 20 }}>
+        <a href="/login">ログイン画面へ</a>
+      </p>
+    </div>
+  );
+}
@@ -27,6 +27,35 @@ export type Message = {
   isRead?: boolean;
   isReadByOthers: boolean; 
   attachments?: {fileName: string}[];
+  thread_root_id?: number | null; // スレッドの返信なら親メッセージのID
+  reply_count?: number;           // スレッドの返信数（親メッセージのみ）
+  last_reply_at?: string | null;  // 最後の返信日時（親メッセージのみ）
+  participant_ids?: number[];     // 返信したユーザーID（親メッセージのみ）
+  reactions?: Reaction[];         // 絵文字ごとのリアクション数
+  edited_at?: string | null;      // 最後に編集した日時（未編集なら null）
+};
+
+// =========================
+// 🔹 メッセージの編集履歴
+// =========================
+// - 使用箇所: 編集履歴の表示（GET /messages/:id/history）
+export type MessageRevision = {
+  id: number;
+  message_id: number;
+  editor_id: number;    // 編集したユーザーID
+  old_content: string;  // 編集前の本文
+  new_content: string;  // 編集後の本文
+  edited_at: string;    // 編集日時
+};
+
+// =========================
+// 🔹 リアクションの集計
+// =========================
+// - 使用箇所: メッセージ一覧のリアクション表示、WebSocket の reaction イベントでの更新
+export type Reaction = {
+  emoji: string;  // 絵文字（または :shortcode:）
+  count: number;  // 付けた人数
+  me: boolean;    // 自分も付けているか
 };
 
 export type Attachment = {